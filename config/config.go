@@ -25,16 +25,32 @@ type Config struct {
 	// Session
 	SessionTimeout  time.Duration
 	CleanupInterval time.Duration
+	LoginTimeout    time.Duration // скільки живе незавершена спроба входу
+
+	// Session storage
+	SessionStore         string // "memory", "file" або "sql"
+	SessionDir           string
+	SessionEncryptionKey string
+	SessionTTL           time.Duration
+
+	// Gateway tokens
+	TokenTTL          time.Duration
+	RefreshTokenTTL   time.Duration
+	LegacySessionAuth bool // приймати X-Phone/X-Session-Data від старих клієнтів
 
 	// Long Polling
 	PollTimeout time.Duration
 
+	// Формат chat_id у відповідях: "marked" або "legacy" (сирі ID)
+	ChatIDMode string
+
 	// Database (для production)
 	DBHost     string
 	DBPort     string
 	DBName     string
 	DBUser     string
 	DBPassword string
+	DBSSLMode  string
 
 	// Redis (для production)
 	RedisHost     string
@@ -80,13 +96,25 @@ func LoadConfig() (*Config, error) {
 
 		SessionTimeout:  parseDuration(getEnv("SESSION_TIMEOUT", "30m")),
 		CleanupInterval: parseDuration(getEnv("CLEANUP_INTERVAL", "5m")),
+		LoginTimeout:    parseDuration(getEnv("LOGIN_TIMEOUT", "10m")),
 		PollTimeout:     parseDuration(getEnv("POLL_TIMEOUT", "50s")),
+		ChatIDMode:      getEnv("CHAT_ID_MODE", "marked"),
+
+		SessionStore:         getEnv("SESSION_STORE", "memory"),
+		SessionDir:           getEnv("SESSION_DIR", "sessions"),
+		SessionEncryptionKey: getEnv("SESSION_ENCRYPTION_KEY", ""),
+		SessionTTL:           parseDuration(getEnv("SESSION_TTL", "720h")),
+
+		TokenTTL:          parseDuration(getEnv("TOKEN_TTL", "24h")),
+		RefreshTokenTTL:   parseDuration(getEnv("REFRESH_TOKEN_TTL", "720h")),
+		LegacySessionAuth: getEnv("LEGACY_SESSION_AUTH", "false") == "true",
 
 		DBHost:     getEnv("DB_HOST", "localhost"),
 		DBPort:     getEnv("DB_PORT", "5432"),
 		DBName:     getEnv("DB_NAME", "telegram_gateway"),
 		DBUser:     getEnv("DB_USER", "postgres"),
 		DBPassword: getEnv("DB_PASSWORD", ""),
+		DBSSLMode:  getEnv("DB_SSLMODE", "disable"),
 
 		RedisHost:     getEnv("REDIS_HOST", "localhost"),
 		RedisPort:     getEnv("REDIS_PORT", "6379"),
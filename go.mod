@@ -6,6 +6,8 @@ require (
 	github.com/gin-gonic/gin v1.11.0
 	github.com/gotd/td v0.131.0
 	github.com/joho/godotenv v1.5.1
+	github.com/lib/pq v1.12.3
+	rsc.io/qr v0.2.0
 )
 
 require (
@@ -64,5 +66,4 @@ require (
 	golang.org/x/tools v0.36.0 // indirect
 	google.golang.org/protobuf v1.36.9 // indirect
 	gopkg.in/yaml.v2 v2.4.0 // indirect
-	rsc.io/qr v0.2.0 // indirect
 )
@@ -1,44 +1,119 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"image/gif"
+	"image/png"
 	"log"
 	"strconv"
+	"strings"
 	"sync"
 	"telegram-gateway/config"
+	"telegram-gateway/storage"
 	tgclient "telegram-gateway/telegram"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gotd/td/tg"
 )
 
 // Structures
 type User struct {
 	ID             string
 	Phone          string
+	Device         *storage.Device // nil для токенів без профілю пристрою
+	SessionKey     string          // ключ сесії акаунта в сховищі
 	TelegramClient *tgclient.Client
 	LastActivity   time.Time
 }
 
+// AccountSummary - акаунт пристрою з кількістю непрочитаних
+type AccountSummary struct {
+	AccountID   string `json:"account_id"`
+	Phone       string `json:"phone"`
+	Active      bool   `json:"active"`
+	Current     bool   `json:"current"`
+	UnreadCount *int   `json:"unread_count"`
+}
+
 type AuthRequest struct {
 	Phone string `json:"phone"`
 }
 
+type LoginAttemptRequest struct {
+	LoginID string `json:"login_id"`
+	Phone   string `json:"phone"`
+}
+
 type AuthCodeRequest struct {
-	Phone string `json:"phone"`
-	Code  string `json:"code"`
+	LoginID string `json:"login_id"`
+	Phone   string `json:"phone"`
+	Code    string `json:"code"`
 }
 
 type AuthPasswordRequest struct {
+	LoginID  string `json:"login_id"`
 	Phone    string `json:"phone"`
 	Password string `json:"password"`
 }
 
+type SignUpRequest struct {
+	LoginID     string `json:"login_id"`
+	Phone       string `json:"phone"`
+	FirstName   string `json:"first_name"`
+	LastName    string `json:"last_name"`
+	AcceptTerms bool   `json:"accept_terms"`
+}
+
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
 type SendMessageRequest struct {
-	ChatID string `json:"chat_id"`
-	Text   string `json:"text"`
+	ChatID    string `json:"chat_id"`
+	Text      string `json:"text"`
+	ParseMode string `json:"parse_mode"`
+	ReplyTo   int    `json:"reply_to"`
+	// Ключ ідемпотентності: повтор з тим самим ключем не надсилає повідомлення вдруге
+	IdempotencyKey string `json:"idempotency_key"`
+	// Поставити в чергу відправки, якщо одразу надіслати не вдасться
+	Queue bool `json:"queue"`
+}
+
+// Найбільша довжина ключа ідемпотентності
+const maxIdempotencyKeyLength = 128
+
+type ForwardMessagesRequest struct {
+	FromChatID string `json:"from_chat_id"`
+	ToChatID   string `json:"to_chat_id"`
+	MessageIDs []int  `json:"message_ids"`
+	DropAuthor bool   `json:"drop_author"`
+}
+
+type EditMessageRequest struct {
+	ChatID    string `json:"chat_id"`
+	MessageID int    `json:"message_id"`
+	Text      string `json:"text"`
+	ParseMode string `json:"parse_mode"`
+}
+
+type DeleteMessagesRequest struct {
+	ChatID      string `json:"chat_id"`
+	MessageIDs  []int  `json:"message_ids"`
+	ForEveryone bool   `json:"for_everyone"`
+}
+
+type SwitchAccountRequest struct {
+	AccountID string `json:"account_id"`
+}
+
+type RevokeSessionRequest struct {
+	Hash string `json:"hash"`
 }
 
 type MarkReadRequest struct {
@@ -48,7 +123,12 @@ type MarkReadRequest struct {
 
 // Global storage
 var (
-	appConfig *config.Config
+	appConfig    *config.Config
+	sessionStore storage.SessionStore
+	tokens       *storage.Tokens
+	devices      *storage.Devices
+	connections  *tgclient.Manager
+	logins       *tgclient.LoginManager
 )
 
 func main() {
@@ -63,13 +143,38 @@ func main() {
 	log.Printf("API ID: %d", cfg.TelegramAPIID)
 	log.Printf("Server: %s:%s", cfg.ServerHost, cfg.ServerPort)
 
+	// Сховище MTProto сесій
+	sessionStore, err = storage.Open(cfg)
+	if err != nil {
+		log.Fatal("Failed to open session store:", err)
+	}
+	defer sessionStore.Close()
+	log.Printf("Session store: %s", cfg.SessionStore)
+
+	// Токени шлюзу, які пристрої передають замість сесії
+	tokens = storage.NewTokens(sessionStore, cfg.TokenTTL, cfg.RefreshTokenTTL)
+	go runTokenCleanup(context.Background())
+
+	// Профілі пристроїв: одному пристрою може належати кілька акаунтів
+	devices = storage.NewDevices(sessionStore)
+
+	// Пул постійних з'єднань з Telegram (одне на акаунт)
+	connections = tgclient.NewManager(cfg, sessionStore)
+	go connections.RunCleanup(context.Background())
+	go connections.RunOutbox(context.Background())
+
+	// Спроби входу: стан кожної веде власна машина станів
+	logins = tgclient.NewLoginManager(cfg, sessionStore)
+	go logins.RunCleanup(context.Background())
+
 	r := gin.Default()
 
 	// CORS
 	r.Use(func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Phone, X-Session-Data")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Phone, X-Session-Data, X-Chat-ID-Mode, X-Account-ID, X-Text-Format, If-None-Match, Idempotency-Key")
+		c.Writer.Header().Set("Access-Control-Expose-Headers", "ETag, Retry-After")
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
 			return
@@ -83,6 +188,14 @@ func main() {
 		auth.POST("/request-code", requestAuthCode)
 		auth.POST("/login", login)
 		auth.POST("/password", submitPassword)
+		auth.POST("/signup", signUp)
+		auth.POST("/resend-code", resendAuthCode)
+		auth.POST("/cancel", cancelLogin)
+		auth.POST("/refresh", refreshToken)
+		auth.POST("/logout", logout)
+		auth.POST("/qr", requestQRLogin)
+		auth.GET("/qr/:login_id/image", getQRImage)
+		auth.GET("/qr/:login_id/wait", waitQRLogin)
 	}
 
 	api := r.Group("/api")
@@ -92,10 +205,29 @@ func main() {
 		authenticated.Use(authMiddleware())
 		{
 			authenticated.GET("/chats", getChats)
+			authenticated.GET("/folders", getFolders)
 			authenticated.GET("/messages/:chat_id", getMessages)
-			authenticated.POST("/send", sendMessage)
+			authenticated.POST("/forward", forwardMessages)
+			authenticated.POST("/edit", editMessage)
+			authenticated.POST("/delete", deleteMessages)
 			authenticated.POST("/mark-read", markAsRead)
 			authenticated.GET("/poll/:chat_id", pollMessages)
+			authenticated.GET("/updates", pollUpdates)
+			authenticated.GET("/accounts", getAccounts)
+			authenticated.POST("/accounts/switch", switchAccount)
+			authenticated.GET("/account/sessions", getSessions)
+			authenticated.POST("/account/sessions/revoke", revokeSession)
+			authenticated.POST("/account/sessions/revoke-others", revokeOtherSessions)
+		}
+
+		// Черга відправки працює і тоді, коли Telegram недоступний:
+		// з'єднання відкривається лише для відправки (див. sendMessage)
+		queued := api.Group("")
+		queued.Use(accountMiddleware())
+		{
+			queued.POST("/send", sendMessage)
+			queued.GET("/outbox", getOutbox)
+			queued.GET("/outbox/:id", getOutboxMessage)
 		}
 
 		// Photo endpoint без middleware (використовує token з query)
@@ -109,131 +241,153 @@ func main() {
 
 // AUTH HANDLERS
 
-// Тимчасове сховище для клієнтів, що авторизуються
-var (
-	pendingClients      = make(map[string]*tgclient.Client)
-	pendingClientsMutex sync.RWMutex
-)
+// loginWaitTimeout - скільки запит входу чекає відповіді Telegram
+const loginWaitTimeout = 60 * time.Second
 
 func requestAuthCode(c *gin.Context) {
 	var req AuthRequest
-	if err := c.BindJSON(&req); err != nil {
+	if err := c.BindJSON(&req); err != nil || req.Phone == "" {
 		c.JSON(400, gin.H{"error": "Invalid request"})
 		return
 	}
 
 	log.Printf("Auth code requested for: %s", req.Phone)
 
-	// Створюємо Telegram клієнт
-	client, err := tgclient.NewClient(appConfig)
+	ctx, cancel := context.WithTimeout(c.Request.Context(), loginWaitTimeout)
+	defer cancel()
+
+	// Повертаємось лише після того, як Telegram підтвердив відправку коду
+	attempt, err := logins.Start(ctx, req.Phone)
 	if err != nil {
-		c.JSON(500, gin.H{"error": "Failed to create client"})
+		log.Printf("requestAuthCode: ERROR - %v", err)
+		var authErr *tgclient.AuthError
+		if errors.As(err, &authErr) && authErr.Code != tgclient.AuthErrFailed {
+			respondAuthError(c, tgclient.LoginFailed, "", authErr)
+			return
+		}
+		c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to send code: %v", err)})
 		return
 	}
 
-	// Зберігаємо клієнт для подальшого використання
-	pendingClientsMutex.Lock()
-	pendingClients[req.Phone] = client
-	pendingClientsMutex.Unlock()
-
-	// Запускаємо авторизацію в окремій горутині
-	go func() {
-		ctx := context.Background()
-		if err := client.Auth(ctx, req.Phone); err != nil {
-			log.Printf("Auth error: %v", err)
-			// Видаляємо клієнт при помилці
-			pendingClientsMutex.Lock()
-			delete(pendingClients, req.Phone)
-			pendingClientsMutex.Unlock()
-		}
-	}()
-
+	sentCode := attempt.SentCode()
 	c.JSON(200, gin.H{
-		"status":  "code_sent",
-		"message": "Код відправлено в Telegram",
+		"status":    tgclient.LoginCodeSent,
+		"login_id":  attempt.ID,
+		"sent_code": sentCode,
+		"message":   sentCodeMessage(sentCode),
 	})
 }
 
-func login(c *gin.Context) {
-	var req AuthCodeRequest
+// sentCodeMessage описує для користувача, куди надіслано код
+func sentCodeMessage(code *tgclient.SentCode) string {
+	switch code.Type {
+	case "sms", "fragment_sms", "firebase_sms":
+		return "Код відправлено SMS"
+	case "call", "flash_call", "missed_call":
+		return "Код буде продиктовано дзвінком"
+	case "email":
+		return "Код відправлено на email"
+	default:
+		return "Код відправлено в Telegram"
+	}
+}
+
+func resendAuthCode(c *gin.Context) {
+	var req LoginAttemptRequest
 	if err := c.BindJSON(&req); err != nil {
 		c.JSON(400, gin.H{"error": "Invalid request"})
 		return
 	}
 
-	log.Printf("Login: Processing for phone: %s", req.Phone)
-
-	// Відправляємо код авторизації
-	if err := tgclient.SubmitCode(req.Phone, req.Code); err != nil {
-		c.JSON(401, gin.H{"error": "Invalid code or no pending auth"})
+	attempt, ok := findLogin(c, req.LoginID, req.Phone)
+	if !ok {
 		return
 	}
+	log.Printf("ResendCode: Processing attempt %s for phone: %s", attempt.ID, attempt.Phone)
 
-	// Даємо час на обробку - Password() може викликатися пізніше
-	time.Sleep(5 * time.Second)
+	ctx, cancel := context.WithTimeout(c.Request.Context(), loginWaitTimeout)
+	defer cancel()
 
-	// Перевіряємо, чи потрібен пароль 2FA
-	for i := 0; i < 20; i++ {
-		if auth, exists := tgclient.GetPendingAuth(req.Phone); exists && auth.NeedsPassword {
-			log.Printf("2FA password required for: %s", req.Phone)
-			c.JSON(200, gin.H{
-				"status":         "password_required",
-				"message":        "Обліковий запис захищено 2FA паролем",
-				"needs_password": true,
-			})
+	sentCode, err := attempt.ResendCode(ctx)
+	if err != nil {
+		state, _ := attempt.State()
+		if errors.Is(err, tgclient.ErrLoginState) {
+			c.JSON(409, gin.H{"error": err.Error(), "status": state, "login_id": attempt.ID})
 			return
 		}
-		time.Sleep(500 * time.Millisecond)
+		log.Printf("ResendCode: ERROR - %v", err)
+		respondAuthError(c, state, attempt.ID, tgclient.AsAuthError(err))
+		return
 	}
 
-	// Якщо пароль не потрібен - завершуємо авторизацію
-	log.Printf("No 2FA password required for: %s, completing login", req.Phone)
-
-	// Очікуємо завершення авторизації
-	time.Sleep(2 * time.Second)
+	c.JSON(200, gin.H{
+		"status":    tgclient.LoginCodeSent,
+		"login_id":  attempt.ID,
+		"sent_code": sentCode,
+		"message":   sentCodeMessage(sentCode),
+	})
+}
 
-	// Очищаємо pending auth
-	tgclient.ClearPendingAuth(req.Phone)
+func cancelLogin(c *gin.Context) {
+	var req LoginAttemptRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request"})
+		return
+	}
 
-	// Отримуємо збережений клієнт
-	pendingClientsMutex.Lock()
-	client, exists := pendingClients[req.Phone]
-	if exists {
-		delete(pendingClients, req.Phone)
+	attempt, ok := findLogin(c, req.LoginID, req.Phone)
+	if !ok {
+		return
 	}
-	pendingClientsMutex.Unlock()
+	log.Printf("CancelLogin: Cancelling attempt %s for phone: %s", attempt.ID, attempt.Phone)
 
-	if !exists {
-		log.Printf("Login: ERROR - No pending client for phone: %s", req.Phone)
-		c.JSON(500, gin.H{"error": "Auth session expired. Please request code again."})
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 30*time.Second)
+	defer cancel()
+
+	if err := logins.Cancel(ctx, attempt.ID); err != nil {
+		c.JSON(404, gin.H{"error": "Login attempt not found or expired. Please request code again.", "status": tgclient.LoginExpired})
 		return
 	}
 
-	// Чекаємо поки session файл буде створений і читаємо його
-	var sessionData string
-	var err error
-	log.Printf("Login: Waiting for session file to be created...")
-	for i := 0; i < 20; i++ {
-		sessionData, err = client.GetSessionData()
-		if err == nil && sessionData != "" {
-			log.Printf("Login: Session data ready after %d attempts (%.1f sec)", i+1, float64(i)*0.5)
-			break
-		}
-		if i < 19 {
-			time.Sleep(500 * time.Millisecond)
-		}
+	c.JSON(200, gin.H{"status": "cancelled"})
+}
+
+// findLogin шукає спробу входу за login_id, а для старих клієнтів - за номером
+func findLogin(c *gin.Context, loginID, phone string) (*tgclient.LoginAttempt, bool) {
+	var (
+		attempt *tgclient.LoginAttempt
+		err     error
+	)
+	if loginID != "" {
+		attempt, err = logins.Get(loginID)
+	} else {
+		attempt, err = logins.GetByPhone(phone)
+	}
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Login attempt not found or expired. Please request code again.", "status": tgclient.LoginExpired})
+		return nil, false
 	}
+	return attempt, true
+}
 
-	if sessionData == "" {
-		log.Printf("Login: WARNING - Failed to get session data after 10 seconds: %v", err)
+func login(c *gin.Context) {
+	var req AuthCodeRequest
+	if err := c.BindJSON(&req); err != nil || req.Code == "" {
+		c.JSON(400, gin.H{"error": "Invalid request"})
+		return
 	}
 
-	log.Printf("Login: SUCCESS for phone: %s", req.Phone)
-	c.JSON(200, gin.H{
-		"status":       "success",
-		"phone":        req.Phone,
-		"session_data": sessionData,
-	})
+	attempt, ok := findLogin(c, req.LoginID, req.Phone)
+	if !ok {
+		return
+	}
+	log.Printf("Login: Processing attempt %s for phone: %s", attempt.ID, attempt.Phone)
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), loginWaitTimeout)
+	defer cancel()
+
+	state, err := attempt.SubmitCode(ctx, req.Code)
+	respondLogin(c, "Login", attempt, state, err)
 }
 
 func submitPassword(c *gin.Context) {
@@ -243,290 +397,1381 @@ func submitPassword(c *gin.Context) {
 		return
 	}
 
-	log.Printf("SubmitPassword: Processing for phone: %s", req.Phone)
-
-	// Відправляємо пароль
-	if err := tgclient.SubmitPassword(req.Phone, req.Password); err != nil {
-		c.JSON(401, gin.H{"error": "No pending password request"})
+	attempt, ok := findLogin(c, req.LoginID, req.Phone)
+	if !ok {
 		return
 	}
+	log.Printf("SubmitPassword: Processing attempt %s for phone: %s", attempt.ID, attempt.Phone)
 
-	// Очищаємо pending auth
-	tgclient.ClearPendingAuth(req.Phone)
+	ctx, cancel := context.WithTimeout(c.Request.Context(), loginWaitTimeout)
+	defer cancel()
 
-	// Отримуємо збережений клієнт
-	pendingClientsMutex.Lock()
-	client, exists := pendingClients[req.Phone]
-	if exists {
-		delete(pendingClients, req.Phone)
-	}
-	pendingClientsMutex.Unlock()
+	state, err := attempt.SubmitPassword(ctx, req.Password)
+	respondLogin(c, "SubmitPassword", attempt, state, err)
+}
 
-	if !exists {
-		log.Printf("SubmitPassword: ERROR - No pending client for phone: %s", req.Phone)
-		c.JSON(500, gin.H{"error": "Auth session expired. Please request code again."})
+func signUp(c *gin.Context) {
+	var req SignUpRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request"})
 		return
 	}
 
-	// Чекаємо поки session файл буде створений і читаємо його
-	var sessionData string
-	var err error
-	log.Printf("SubmitPassword: Waiting for session file to be created...")
-	for i := 0; i < 20; i++ {
-		sessionData, err = client.GetSessionData()
-		if err == nil && sessionData != "" {
-			log.Printf("SubmitPassword: Session data ready after %d attempts (%.1f sec)", i+1, float64(i)*0.5)
-			break
-		}
-		if i < 19 {
-			time.Sleep(500 * time.Millisecond)
-		}
+	req.FirstName = strings.TrimSpace(req.FirstName)
+	req.LastName = strings.TrimSpace(req.LastName)
+	if req.FirstName == "" {
+		c.JSON(400, gin.H{"error": "First name is required", "code": tgclient.AuthErrNameInvalid})
+		return
 	}
-
-	if sessionData == "" {
-		log.Printf("SubmitPassword: WARNING - Failed to get session data after 10 seconds: %v", err)
+	if !req.AcceptTerms {
+		c.JSON(400, gin.H{"error": "Terms of service must be accepted", "code": "terms_not_accepted"})
+		return
 	}
 
-	log.Printf("SubmitPassword: SUCCESS for phone: %s", req.Phone)
-	c.JSON(200, gin.H{
-		"status":       "success",
-		"phone":        req.Phone,
-		"session_data": sessionData,
-	})
-}
-
-// API HANDLERS
+	attempt, ok := findLogin(c, req.LoginID, req.Phone)
+	if !ok {
+		return
+	}
+	log.Printf("SignUp: Processing attempt %s for phone: %s", attempt.ID, attempt.Phone)
 
-func getChats(c *gin.Context) {
-	log.Printf("getChats: Starting request")
+	ctx, cancel := context.WithTimeout(c.Request.Context(), loginWaitTimeout)
+	defer cancel()
 
-	user := c.MustGet("user").(*User)
-	log.Printf("getChats: User ID: %s, Phone: %s", user.ID, user.Phone)
+	state, err := attempt.SubmitSignUp(ctx, req.FirstName, req.LastName)
+	respondLogin(c, "SignUp", attempt, state, err)
+}
 
-	user.LastActivity = time.Now()
+// qrImageSize - розмір QR-коду за замовчуванням (під екран 240x320)
+const qrImageSize = 200
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func requestQRLogin(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), loginWaitTimeout)
 	defer cancel()
 
-	log.Printf("getChats: Calling TelegramClient.GetDialogs")
-	dialogs, err := user.TelegramClient.GetDialogs(ctx, 50)
+	attempt, err := logins.StartQR(ctx)
 	if err != nil {
-		log.Printf("getChats: ERROR - Failed to get dialogs: %v", err)
-		c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to get dialogs: %v", err)})
+		log.Printf("requestQRLogin: ERROR - %v", err)
+		var authErr *tgclient.AuthError
+		if errors.As(err, &authErr) && authErr.Code != tgclient.AuthErrFailed {
+			respondAuthError(c, tgclient.LoginFailed, "", authErr)
+			return
+		}
+		c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to start QR login: %v", err)})
 		return
 	}
 
-	log.Printf("getChats: Successfully got %d dialogs", len(dialogs))
-	c.JSON(200, gin.H{
-		"chats": dialogs,
-		"count": len(dialogs),
-	})
+	log.Printf("QR login started: %s", attempt.ID)
+	c.JSON(200, qrResponse(attempt))
 }
 
-func getMessages(c *gin.Context) {
-	log.Printf("getMessages: Starting request")
-
-	user := c.MustGet("user").(*User)
-	log.Printf("getMessages: User ID: %s", user.ID)
-
-	user.LastActivity = time.Now()
+// qrResponse описує поточний QR-токен спроби
+func qrResponse(attempt *tgclient.LoginAttempt) gin.H {
+	response := gin.H{
+		"status":    tgclient.LoginQRPending,
+		"login_id":  attempt.ID,
+		"image_url": "/auth/qr/" + attempt.ID + "/image",
+		"message":   "Відскануйте QR-код у застосунку Telegram: Налаштування → Пристрої → Підключити пристрій",
+	}
+	if token := attempt.QRToken(); token != nil {
+		response["url"] = token.URL()
+		response["expires_at"] = token.Expires().Unix()
+		response["expires_in"] = int(time.Until(token.Expires()).Seconds())
+	}
+	return response
+}
 
-	chatIDStr := c.Param("chat_id")
-	chatID, err := strconv.ParseInt(chatIDStr, 10, 64)
+func getQRImage(c *gin.Context) {
+	attempt, err := logins.Get(c.Param("login_id"))
 	if err != nil {
-		log.Printf("getMessages: ERROR - Invalid chat_id: %s", chatIDStr)
-		c.JSON(400, gin.H{"error": "Invalid chat_id"})
+		c.JSON(404, gin.H{"error": "Login attempt not found or expired. Please request code again.", "status": tgclient.LoginExpired})
 		return
 	}
 
-	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
-	log.Printf("getMessages: Chat ID: %d, Limit: %d", chatID, limit)
-
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	log.Printf("getMessages: Calling TelegramClient.GetMessages")
-	messages, err := user.TelegramClient.GetMessages(ctx, chatID, limit)
-	if err != nil {
-		log.Printf("getMessages: ERROR - Failed to get messages: %v", err)
-		c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to get messages: %v", err)})
+	token := attempt.QRToken()
+	if token == nil {
+		c.JSON(409, gin.H{"error": "Login attempt has no QR code", "status": tgclient.LoginCodeSent})
 		return
 	}
 
-	log.Printf("getMessages: Successfully got %d messages", len(messages))
-	c.JSON(200, gin.H{
-		"messages": messages,
-		"chat_id":  chatIDStr,
-	})
-}
-
-func sendMessage(c *gin.Context) {
-	log.Printf("sendMessage: Starting request")
-
-	user := c.MustGet("user").(*User)
-	user.LastActivity = time.Now()
-
-	var req SendMessageRequest
-	if err := c.BindJSON(&req); err != nil {
-		log.Printf("sendMessage: ERROR - Invalid request: %v", err)
-		c.JSON(400, gin.H{"error": "Invalid request"})
+	size, err := strconv.Atoi(c.DefaultQuery("size", strconv.Itoa(qrImageSize)))
+	if err != nil || size < 64 || size > 1024 {
+		c.JSON(400, gin.H{"error": "Invalid size"})
 		return
 	}
 
-	log.Printf("sendMessage: Chat ID: %s, Text: %s", req.ChatID, req.Text)
-
-	chatID, err := strconv.ParseInt(req.ChatID, 10, 64)
+	img, err := tgclient.RenderQR(token.URL(), size)
 	if err != nil {
-		log.Printf("sendMessage: ERROR - Invalid chat_id: %s", req.ChatID)
-		c.JSON(400, gin.H{"error": "Invalid chat_id"})
+		log.Printf("getQRImage: ERROR - %v", err)
+		c.JSON(500, gin.H{"error": "Failed to render QR code"})
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	log.Printf("sendMessage: Calling TelegramClient.SendMessage")
-	messageID, err := user.TelegramClient.SendMessage(ctx, chatID, req.Text)
+	var buf bytes.Buffer
+	contentType := "image/png"
+	switch c.DefaultQuery("format", "png") {
+	case "png":
+		err = png.Encode(&buf, img)
+	case "gif":
+		contentType = "image/gif"
+		err = gif.Encode(&buf, img, nil)
+	default:
+		c.JSON(400, gin.H{"error": "Invalid format"})
+		return
+	}
 	if err != nil {
-		log.Printf("sendMessage: ERROR - Failed to send message: %v", err)
-		c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to send message: %v", err)})
+		log.Printf("getQRImage: ERROR - %v", err)
+		c.JSON(500, gin.H{"error": "Failed to render QR code"})
 		return
 	}
 
-	log.Printf("sendMessage: Successfully sent message, ID: %d", messageID)
-	c.JSON(200, gin.H{
-		"status":     "sent",
-		"message_id": messageID,
-		"timestamp":  time.Now(),
-	})
+	c.Header("Cache-Control", "no-store")
+	c.Data(200, contentType, buf.Bytes())
 }
 
-func markAsRead(c *gin.Context) {
-	user := c.MustGet("user").(*User)
-	user.LastActivity = time.Now()
-
-	var req MarkReadRequest
-	if err := c.BindJSON(&req); err != nil {
-		c.JSON(400, gin.H{"error": "Invalid request"})
-		return
-	}
-
-	chatID, err := strconv.ParseInt(req.ChatID, 10, 64)
+// waitQRLogin чекає, поки QR-код буде відскановано (long polling).
+// Повертає новий токен, якщо попередній прострочився.
+func waitQRLogin(c *gin.Context) {
+	attempt, err := logins.Get(c.Param("login_id"))
 	if err != nil {
-		c.JSON(400, gin.H{"error": "Invalid chat_id"})
+		c.JSON(404, gin.H{"error": "Login attempt not found or expired. Please request code again.", "status": tgclient.LoginExpired})
 		return
 	}
+	attempt.Touch()
 
-	if len(req.MessageIDs) == 0 {
-		c.JSON(400, gin.H{"error": "No message IDs provided"})
-		return
+	timeout := 30 * time.Second
+	pollTimeout, _ := strconv.Atoi(c.DefaultQuery("timeout", "30"))
+	if pollTimeout >= 0 && pollTimeout <= 60 {
+		timeout = time.Duration(pollTimeout) * time.Second
 	}
 
-	maxID := req.MessageIDs[0]
-	for _, id := range req.MessageIDs {
-		if id > maxID {
-			maxID = id
+	changed := attempt.Changed()
+	state, err := attempt.State()
+	if state == tgclient.LoginQRPending {
+		select {
+		case <-changed:
+		case <-time.After(timeout):
+			c.Status(204)
+			return
+		case <-c.Request.Context().Done():
+			return
 		}
+		state, err = attempt.State()
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	if err := user.TelegramClient.MarkAsRead(ctx, chatID, maxID); err != nil {
-		c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to mark as read: %v", err)})
+	if state == tgclient.LoginQRPending {
+		c.JSON(200, qrResponse(attempt))
 		return
 	}
-
-	c.JSON(200, gin.H{"status": "marked_read"})
+	respondLogin(c, "QRLogin", attempt, state, err)
 }
 
-func getPhoto(c *gin.Context) {
-	// Намагаємося отримати user з middleware (якщо headers передані)
-	var user *User
-	if u, exists := c.Get("user"); exists {
-		user = u.(*User)
-	} else {
-		// Якщо немає user з middleware, пробуємо token з query параметрів
-		token := c.Query("token")
-		if token == "" {
-			c.JSON(401, gin.H{"error": "Missing authentication"})
-			return
-		}
+// respondLogin відповідає відповідно до стану, в який перейшла спроба входу
+func respondLogin(c *gin.Context, logPrefix string, attempt *tgclient.LoginAttempt, state tgclient.LoginState, err error) {
+	if errors.Is(err, tgclient.ErrLoginState) {
+		c.JSON(409, gin.H{"error": err.Error(), "status": state, "login_id": attempt.ID})
+		return
+	}
 
-		// Декодуємо token (base64 encoded "phone:session_data")
-		decoded, err := base64.StdEncoding.DecodeString(token)
+	switch state {
+	case tgclient.LoginAuthorized:
+		completeLogin(c, logPrefix, attempt)
+	case tgclient.LoginCodeSent, tgclient.LoginPasswordRequired:
 		if err != nil {
-			c.JSON(401, gin.H{"error": "Invalid token"})
+			// Невірний код чи пароль: спроба лишається активною для повтору
+			respondAuthError(c, state, attempt.ID, tgclient.AsAuthError(err))
 			return
 		}
-
-		parts := string(decoded)
-		colonIdx := -1
-		for i, ch := range parts {
-			if ch == ':' {
-				colonIdx = i
-				break
-			}
-		}
-
-		if colonIdx == -1 {
-			c.JSON(401, gin.H{"error": "Invalid token format"})
+		if state == tgclient.LoginCodeSent {
+			c.JSON(409, gin.H{"error": "Code is expected", "status": state, "login_id": attempt.ID})
 			return
 		}
 
-		phone := parts[:colonIdx]
-		sessionData := parts[colonIdx+1:]
-
-		// Створюємо клієнт з session data
-		client, err := tgclient.NewClientWithSession(appConfig, sessionData)
+		log.Printf("2FA password required for: %s", attempt.Phone)
+		c.JSON(200, gin.H{
+			"status":         state,
+			"login_id":       attempt.ID,
+			"message":        "Обліковий запис захищено 2FA паролем",
+			"needs_password": true,
+		})
+	case tgclient.LoginSignUpRequired:
 		if err != nil {
-			c.JSON(401, gin.H{"error": "Invalid session data"})
+			respondAuthError(c, state, attempt.ID, tgclient.AsAuthError(err))
 			return
 		}
 
-		user = &User{
-			ID:             phone,
-			Phone:          phone,
-			TelegramClient: client,
-			LastActivity:   time.Now(),
-		}
+		log.Printf("Sign up required for: %s", attempt.Phone)
+		c.JSON(200, gin.H{
+			"status":           state,
+			"login_id":         attempt.ID,
+			"message":          "Номер не зареєстровано в Telegram. Вкажіть ім'я та прийміть умови використання",
+			"terms_of_service": attempt.Terms(),
+		})
+	case tgclient.LoginCodeSubmitted:
+		// Telegram не відповів за відведений час
+		c.JSON(504, gin.H{"error": "Telegram did not respond in time", "status": state, "login_id": attempt.ID})
+	case tgclient.LoginFailed:
+		log.Printf("%s: ERROR - %v", logPrefix, err)
+		logins.Remove(attempt.ID)
+		respondAuthError(c, state, "", tgclient.AsAuthError(err))
+	default:
+		logins.Remove(attempt.ID)
+		c.JSON(404, gin.H{"error": "Login attempt not found or expired. Please request code again.", "status": tgclient.LoginExpired})
 	}
+}
 
-	user.LastActivity = time.Now()
+// authErrorMessages - тексти для стабільних кодів помилок входу
+var authErrorMessages = map[string]string{
+	tgclient.AuthErrCodeInvalid:     "Invalid code",
+	tgclient.AuthErrCodeExpired:     "Code expired. Use /auth/resend-code to get a new one.",
+	tgclient.AuthErrPasswordInvalid: "Invalid password",
+	tgclient.AuthErrPhoneInvalid:    "Invalid phone number",
+	tgclient.AuthErrFloodWait:       "Too many attempts. Please wait before retrying.",
+	tgclient.AuthErrNameInvalid:     "Invalid first or last name",
+}
 
-	chatIDStr := c.Param("chat_id")
-	messageIDStr := c.Param("message_id")
+// respondAuthError відповідає помилкою входу з машиночитним кодом
+func respondAuthError(c *gin.Context, state tgclient.LoginState, loginID string, authErr *tgclient.AuthError) {
+	status := 401
+	switch authErr.Code {
+	case tgclient.AuthErrPhoneInvalid, tgclient.AuthErrNameInvalid:
+		status = 400
+	case tgclient.AuthErrFloodWait:
+		status = 429
+	}
 
-	chatID, err := strconv.ParseInt(chatIDStr, 10, 64)
-	if err != nil {
-		c.JSON(400, gin.H{"error": "Invalid chat_id"})
+	message, exists := authErrorMessages[authErr.Code]
+	if !exists {
+		message = fmt.Sprintf("Login failed: %v", authErr.Err)
+	}
+
+	response := gin.H{
+		"error":  message,
+		"code":   authErr.Code,
+		"status": state,
+	}
+	if loginID != "" {
+		response["login_id"] = loginID
+	}
+	if authErr.Code == tgclient.AuthErrFloodWait {
+		retryAfter := authErr.RetryAfter()
+		response["retry_after"] = retryAfter
+		c.Header("Retry-After", strconv.Itoa(retryAfter))
+	}
+	c.JSON(status, response)
+}
+
+// completeLogin переносить сесію акаунта в постійне сховище шлюзу
+// і видає клієнту токени замість сирих даних сесії
+func completeLogin(c *gin.Context, logPrefix string, attempt *tgclient.LoginAttempt) {
+	// Спроба видаляється разом з тимчасовою сесією після перенесення
+	defer logins.Remove(attempt.ID)
+
+	client, phone := attempt.Client, attempt.Phone
+	self := client.Self()
+	if self == nil {
+		log.Printf("%s: ERROR - Authorization did not complete for phone: %s", logPrefix, phone)
+		c.JSON(500, gin.H{"error": "Auth session expired. Please request code again."})
 		return
 	}
+	if phone == "" && self.Phone != "" {
+		// Вхід за QR-кодом: номер відомий лише після авторизації
+		phone = "+" + self.Phone
+	}
 
-	messageID, err := strconv.Atoi(messageIDStr)
+	ctx := c.Request.Context()
+	accountID := strconv.FormatInt(self.ID, 10)
+	key := tgclient.SessionKey(accountID)
+
+	data, err := sessionStore.Load(ctx, client.SessionKey)
 	if err != nil {
-		c.JSON(400, gin.H{"error": "Invalid message_id"})
+		log.Printf("%s: ERROR - Failed to load session: %v", logPrefix, err)
+		c.JSON(500, gin.H{"error": "Failed to save session"})
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
+	// Старе з'єднання акаунта тримає попередній ключ авторизації
+	connections.Remove(key)
+	if err := sessionStore.Save(ctx, key, data); err != nil {
+		log.Printf("%s: ERROR - Failed to save session: %v", logPrefix, err)
+		c.JSON(500, gin.H{"error": "Failed to save session"})
+		return
+	}
 
-	photoData, err := user.TelegramClient.GetPhotoData(ctx, messageID, chatID)
+	// Вхід з токеном пристрою додає акаунт до цього пристрою
+	device, err := loginDevice(c)
+	if err == nil {
+		device, err = devices.Link(ctx, device.ID, accountID, phone)
+	}
 	if err != nil {
-		log.Printf("getPhoto: ERROR - Failed to get photo: %v", err)
-		c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to get photo: %v", err)})
+		log.Printf("%s: ERROR - Failed to link account to device: %v", logPrefix, err)
+		c.JSON(500, gin.H{"error": "Failed to save device"})
 		return
 	}
 
-	// Повертаємо зображення
-	c.Data(200, "image/jpeg", photoData)
-}
+	pair, err := tokens.Issue(ctx, device.ID, accountID, phone)
+	if err != nil {
+		log.Printf("%s: ERROR - Failed to issue tokens: %v", logPrefix, err)
+		c.JSON(500, gin.H{"error": "Failed to issue token"})
+		return
+	}
 
-func pollMessages(c *gin.Context) {
-	log.Printf("pollMessages: Starting request")
+	response := tokenResponse(pair)
+	response["status"] = "success"
+	response["phone"] = phone
+	response["account_id"] = accountID
+	response["device_id"] = device.ID
+	response["accounts"] = device.Accounts
+	if appConfig.LegacySessionAuth {
+		response["session_data"] = base64.StdEncoding.EncodeToString(data)
+	}
 
-	sessionData := c.GetHeader("X-Session-Data")
+	log.Printf("%s: SUCCESS for phone: %s (account %s)", logPrefix, phone, accountID)
+	c.JSON(200, response)
+}
+
+// loginDevice повертає пристрій, з токеном якого виконується вхід,
+// або створює новий профіль пристрою
+func loginDevice(c *gin.Context) (*storage.Device, error) {
+	ctx := c.Request.Context()
+
+	header := c.GetHeader("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return devices.Create(ctx)
+	}
+	info, err := tokens.Resolve(ctx, strings.TrimSpace(strings.TrimPrefix(header, "Bearer ")))
+	if err != nil {
+		return devices.Create(ctx)
+	}
+
+	if info.DeviceID != "" {
+		if device, err := devices.Get(ctx, info.DeviceID); err == nil {
+			return device, nil
+		}
+		return devices.Create(ctx)
+	}
+
+	// Токен виданий до появи профілів: переносимо його акаунт у новий пристрій
+	device, err := devices.Create(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return devices.Link(ctx, device.ID, info.AccountID, info.Phone)
+}
+
+// logout завершує сесію шлюзу в Telegram (auth.logOut) і видаляє
+// все, що шлюз зберігав для акаунта: сесію, стан оновлень і токени
+func logout(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 30*time.Second)
+	defer cancel()
+
+	var (
+		key, accountID string
+		device         *storage.Device
+	)
+	if header := c.GetHeader("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		// Токен перевіряємо без з'єднання: вийти можна і з уже відкликаної сесії
+		info, err := tokens.Resolve(ctx, strings.TrimSpace(strings.TrimPrefix(header, "Bearer ")))
+		if err == nil {
+			var account storage.DeviceAccount
+			device, account, err = selectAccount(c, info)
+			accountID = account.AccountID
+		}
+		if err != nil {
+			abortUnauthorized(c, storageError(err))
+			return
+		}
+		key = tgclient.SessionKey(accountID)
+	} else {
+		user, err := authenticate(c)
+		if err != nil {
+			abortUnauthorized(c, err)
+			return
+		}
+		key = user.SessionKey
+	}
+
+	log.Printf("Logout: Processing for %s", key)
+
+	if client, err := acquireClient(c, key); err == nil {
+		if err := client.LogOut(ctx); err != nil {
+			log.Printf("Logout: ERROR - Telegram log out failed: %v", err)
+		}
+	} else {
+		log.Printf("Logout: Session %s is not usable: %v", key, err)
+	}
+
+	connections.Purge(key)
+	if accountID != "" {
+		if err := tokens.RevokeAccount(ctx, accountID); err != nil {
+			log.Printf("Logout: ERROR - Failed to revoke tokens: %v", err)
+		}
+
+		// Сесія акаунта спільна для всіх пристроїв, тож відв'язуємо його скрізь;
+		// пристрої без акаунтів втрачають і свої токени
+		emptied, err := devices.Unlink(ctx, accountID)
+		if err != nil {
+			log.Printf("Logout: ERROR - Failed to unlink account: %v", err)
+		}
+		for _, id := range emptied {
+			if err := tokens.RevokeDevice(ctx, id); err != nil {
+				log.Printf("Logout: ERROR - Failed to revoke device tokens: %v", err)
+			}
+		}
+	}
+
+	response := gin.H{"status": "logged_out"}
+	if device != nil {
+		if remaining, err := devices.Get(ctx, device.ID); err == nil {
+			response["active"] = remaining.Active
+			response["accounts"] = remaining.Accounts
+		} else {
+			response["accounts"] = []storage.DeviceAccount{}
+		}
+	}
+	c.JSON(200, response)
+}
+
+// tokenResponse формує JSON з парою токенів
+func tokenResponse(pair *storage.TokenPair) gin.H {
+	return gin.H{
+		"access_token":  pair.AccessToken,
+		"refresh_token": pair.RefreshToken,
+		"token_type":    "Bearer",
+		"expires_in":    int(pair.ExpiresIn.Seconds()),
+	}
+}
+
+func refreshToken(c *gin.Context) {
+	var req RefreshTokenRequest
+	if err := c.BindJSON(&req); err != nil || req.RefreshToken == "" {
+		c.JSON(400, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	pair, info, err := tokens.Refresh(c.Request.Context(), req.RefreshToken)
+	if err != nil {
+		log.Printf("refreshToken: ERROR - %v", err)
+		c.JSON(401, gin.H{"error": "Invalid refresh token", "code": "invalid_refresh_token"})
+		return
+	}
+
+	response := tokenResponse(pair)
+	response["status"] = "success"
+	response["phone"] = info.Phone
+	response["account_id"] = info.AccountID
+	if info.DeviceID != "" {
+		// Профіль живе, поки пристрій оновлює токени
+		if err := devices.Touch(c.Request.Context(), info.DeviceID); err != nil {
+			log.Printf("refreshToken: ERROR - Failed to touch device: %v", err)
+		}
+		response["device_id"] = info.DeviceID
+	}
+	c.JSON(200, response)
+}
+
+// API HANDLERS
+
+func getChats(c *gin.Context) {
+	log.Printf("getChats: Starting request")
+
+	user := c.MustGet("user").(*User)
+	log.Printf("getChats: User ID: %s, Phone: %s", user.ID, user.Phone)
+
+	user.LastActivity = time.Now()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit < 1 || limit > 100 {
+		c.JSON(400, gin.H{"error": "Invalid limit (1-100)"})
+		return
+	}
+
+	var cursor *tgclient.DialogCursor
+	if raw := c.Query("cursor"); raw != "" {
+		if cursor, err = tgclient.ParseDialogCursor(raw); err != nil {
+			c.JSON(400, gin.H{"error": "Invalid cursor", "code": "invalid_cursor"})
+			return
+		}
+	}
+
+	// since - версія з попередньої відповіді: тоді віддаються лише змінені чати
+	var since int64
+	if raw := c.Query("since"); raw != "" {
+		if since, err = strconv.ParseInt(raw, 10, 64); err != nil || since < 0 {
+			c.JSON(400, gin.H{"error": "Invalid since"})
+			return
+		}
+	}
+
+	// Без folder_id - усі чати разом з архівом
+	folderID := tgclient.AllFolders
+	if raw := c.Query("folder_id"); raw != "" {
+		if folderID, err = strconv.Atoi(raw); err != nil || folderID < 0 {
+			c.JSON(400, gin.H{"error": "Invalid folder_id"})
+			return
+		}
+	}
+
+	var page *tgclient.DialogsPage
+	if folderID > tgclient.ArchiveFolderID {
+		log.Printf("getChats: Calling TelegramClient.GetFolderDialogsPage, folder %d", folderID)
+		page, err = user.TelegramClient.GetFolderDialogsPage(ctx, folderID, limit, cursor)
+	} else {
+		log.Printf("getChats: Calling TelegramClient.GetDialogsPage, folder %d", folderID)
+		page, err = user.TelegramClient.GetDialogsPage(ctx, folderID, limit, cursor)
+	}
+	if errors.Is(err, tgclient.ErrFolderNotFound) {
+		c.JSON(404, gin.H{"error": "Folder not found", "code": "folder_not_found"})
+		return
+	}
+	if err != nil {
+		log.Printf("getChats: ERROR - Failed to get dialogs: %v", err)
+		c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to get dialogs: %v", err)})
+		return
+	}
+
+	accounts, totalUnread := accountSummaries(c, user)
+
+	etag := chatsETag(c, page, accounts, since)
+	c.Header("ETag", etag)
+	if etagMatches(c.GetHeader("If-None-Match"), etag) {
+		log.Printf("getChats: Not modified")
+		c.Status(304)
+		return
+	}
+
+	dialogs := page.Dialogs
+	if since > 0 {
+		dialogs = page.ChangedSince(since)
+	}
+	if dialogs == nil {
+		dialogs = []tgclient.Dialog{}
+	}
+
+	if legacyChatIDs(c) {
+		for i := range dialogs {
+			dialogs[i].ID = tgclient.RawChatID(dialogs[i].ID)
+		}
+	}
+
+	log.Printf("getChats: Successfully got %d dialogs", len(dialogs))
+	var nextCursor interface{}
+	if page.NextCursor != nil {
+		nextCursor = page.NextCursor.Encode()
+	}
+
+	response := gin.H{
+		"chats":        dialogs,
+		"count":        len(dialogs),
+		"total":        page.Total,
+		"next_cursor":  nextCursor,
+		"has_more":     page.NextCursor != nil,
+		"version":      page.Version,
+		"account_id":   user.ID,
+		"accounts":     accounts,
+		"total_unread": totalUnread,
+	}
+	if since > 0 {
+		response["since"] = since
+	}
+	c.JSON(200, response)
+}
+
+// chatsETag будує ETag відповіді /api/chats: вміст сторінки, лічильники
+// акаунтів і все, що змінює вигляд відповіді для того самого вмісту
+func chatsETag(c *gin.Context, page *tgclient.DialogsPage, accounts []AccountSummary, since int64) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s|%d|%t|%d|", page.ETag(), page.Version, legacyChatIDs(c), since)
+	for _, account := range accounts {
+		unread := -1
+		if account.UnreadCount != nil {
+			unread = *account.UnreadCount
+		}
+		fmt.Fprintf(h, "%s:%t:%t:%d|", account.AccountID, account.Active, account.Current, unread)
+	}
+	return fmt.Sprintf("\"%x\"", h.Sum(nil)[:12])
+}
+
+// etagMatches перевіряє If-None-Match (список ETag через кому або "*")
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+func getFolders(c *gin.Context) {
+	user := c.MustGet("user").(*User)
+	user.LastActivity = time.Now()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	folders, err := user.TelegramClient.GetFolders(ctx)
+	if err != nil {
+		log.Printf("getFolders: ERROR - Failed to get folders: %v", err)
+		c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to get folders: %v", err)})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"folders": folders,
+		"count":   len(folders),
+	})
+}
+
+func getAccounts(c *gin.Context) {
+	user := c.MustGet("user").(*User)
+	user.LastActivity = time.Now()
+
+	accounts, totalUnread := accountSummaries(c, user)
+
+	response := gin.H{
+		"account_id":   user.ID,
+		"accounts":     accounts,
+		"total_unread": totalUnread,
+	}
+	if user.Device != nil {
+		response["device_id"] = user.Device.ID
+		response["active"] = user.Device.Active
+	}
+	c.JSON(200, response)
+}
+
+func switchAccount(c *gin.Context) {
+	user := c.MustGet("user").(*User)
+	user.LastActivity = time.Now()
+
+	var req SwitchAccountRequest
+	if err := c.BindJSON(&req); err != nil || req.AccountID == "" {
+		c.JSON(400, gin.H{"error": "Invalid request"})
+		return
+	}
+	if user.Device == nil {
+		c.JSON(400, gin.H{"error": "Token has no device profile. Please log in again.", "code": "no_device"})
+		return
+	}
+
+	device, err := devices.SetActive(c.Request.Context(), user.Device.ID, req.AccountID)
+	if errors.Is(err, storage.ErrAccountNotLinked) {
+		c.JSON(403, gin.H{"error": "Account is not linked to this device", "code": "account_not_linked"})
+		return
+	}
+	if err != nil {
+		log.Printf("switchAccount: ERROR - %v", err)
+		c.JSON(500, gin.H{"error": "Failed to switch account"})
+		return
+	}
+
+	log.Printf("switchAccount: Device %s switched to %s", device.ID, device.Active)
+	c.JSON(200, gin.H{
+		"status":   "switched",
+		"active":   device.Active,
+		"accounts": device.Accounts,
+	})
+}
+
+// accountSummaries збирає кількість непрочитаних по всіх акаунтах пристрою.
+// Недоступні акаунти повертаються з unread_count: null.
+func accountSummaries(c *gin.Context, user *User) ([]AccountSummary, int) {
+	accounts := []storage.DeviceAccount{{AccountID: user.ID, Phone: user.Phone}}
+	active := user.ID
+	if user.Device != nil {
+		accounts = user.Device.Accounts
+		active = user.Device.Active
+	}
+
+	summaries := make([]AccountSummary, len(accounts))
+	var wg sync.WaitGroup
+	for i, account := range accounts {
+		summaries[i] = AccountSummary{
+			AccountID: account.AccountID,
+			Phone:     account.Phone,
+			Active:    account.AccountID == active,
+			Current:   account.AccountID == user.ID,
+		}
+
+		wg.Add(1)
+		go func(summary *AccountSummary) {
+			defer wg.Done()
+
+			client := user.TelegramClient
+			if summary.AccountID != user.ID {
+				var err error
+				if client, err = acquireClient(c, tgclient.SessionKey(summary.AccountID)); err != nil {
+					log.Printf("accountSummaries: Account %s unavailable: %v", summary.AccountID, err)
+					return
+				}
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			unread, err := client.UnreadCount(ctx)
+			if err != nil {
+				log.Printf("accountSummaries: ERROR - Account %s: %v", summary.AccountID, err)
+				return
+			}
+			summary.UnreadCount = &unread
+		}(&summaries[i])
+	}
+	wg.Wait()
+
+	total := 0
+	for _, summary := range summaries {
+		if summary.UnreadCount != nil {
+			total += *summary.UnreadCount
+		}
+	}
+	return summaries, total
+}
+
+func getMessages(c *gin.Context) {
+	log.Printf("getMessages: Starting request")
+
+	user := c.MustGet("user").(*User)
+	log.Printf("getMessages: User ID: %s", user.ID)
+
+	user.LastActivity = time.Now()
+
+	chatIDStr := c.Param("chat_id")
+	chatID, err := strconv.ParseInt(chatIDStr, 10, 64)
+	if err != nil {
+		log.Printf("getMessages: ERROR - Invalid chat_id: %s", chatIDStr)
+		c.JSON(400, gin.H{"error": "Invalid chat_id"})
+		return
+	}
+
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if limit <= 0 || limit > 100 {
+		limit = 50
+	}
+
+	query := tgclient.HistoryQuery{Limit: limit}
+	anchors := 0
+	for name, dst := range map[string]*int{
+		"before_id":   &query.BeforeID,
+		"after_id":    &query.AfterID,
+		"around_id":   &query.AroundID,
+		"offset_date": &query.OffsetDate,
+	} {
+		raw := c.Query(name)
+		if raw == "" {
+			continue
+		}
+		value, err := strconv.Atoi(raw)
+		if err != nil || value <= 0 {
+			log.Printf("getMessages: ERROR - Invalid %s: %s", name, raw)
+			c.JSON(400, gin.H{"error": fmt.Sprintf("Invalid %s", name), "code": "invalid_offset"})
+			return
+		}
+		*dst = value
+		if name != "offset_date" {
+			anchors++
+		}
+	}
+	if anchors > 1 {
+		c.JSON(400, gin.H{"error": "Only one of before_id, after_id, around_id is allowed", "code": "invalid_offset"})
+		return
+	}
+	log.Printf("getMessages: Chat ID: %d, Query: %+v", chatID, query)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	log.Printf("getMessages: Calling TelegramClient.GetHistory")
+	page, err := user.TelegramClient.GetHistory(ctx, chatID, query)
+	if err != nil {
+		log.Printf("getMessages: ERROR - Failed to get messages: %v", err)
+		c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to get messages: %v", err)})
+		return
+	}
+
+	messages := page.Messages
+	if messages == nil {
+		messages = []tgclient.Message{}
+	}
+	presentMessages(c, messages)
+
+	log.Printf("getMessages: Successfully got %d messages", len(messages))
+	c.JSON(200, gin.H{
+		"messages":       messages,
+		"chat_id":        chatIDStr,
+		"has_more_older": page.HasMoreOlder,
+		"has_more_newer": page.HasMoreNewer,
+	})
+}
+
+func sendMessage(c *gin.Context) {
+	log.Printf("sendMessage: Starting request")
+
+	user := c.MustGet("user").(*User)
+	user.LastActivity = time.Now()
+
+	var req SendMessageRequest
+	if err := c.BindJSON(&req); err != nil {
+		log.Printf("sendMessage: ERROR - Invalid request: %v", err)
+		c.JSON(400, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	log.Printf("sendMessage: Chat ID: %s, Text: %s", req.ChatID, req.Text)
+
+	chatID, err := strconv.ParseInt(req.ChatID, 10, 64)
+	if err != nil {
+		log.Printf("sendMessage: ERROR - Invalid chat_id: %s", req.ChatID)
+		c.JSON(400, gin.H{"error": "Invalid chat_id"})
+		return
+	}
+
+	if req.ReplyTo < 0 {
+		c.JSON(400, gin.H{"error": "Invalid reply_to"})
+		return
+	}
+
+	key := req.IdempotencyKey
+	if key == "" {
+		key = c.GetHeader("Idempotency-Key")
+	}
+	if len(key) > maxIdempotencyKeyLength {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("idempotency_key is too long (max %d)", maxIdempotencyKeyLength)})
+		return
+	}
+	var randomID int64
+	if key != "" {
+		randomID = tgclient.IdempotencyRandomID(key)
+	}
+
+	if req.Queue {
+		queueMessage(c, user, chatID, req, randomID)
+		return
+	}
+
+	if err := connectUser(c, user); err != nil {
+		log.Printf("sendMessage: ERROR - %v", err)
+		abortUnauthorized(c, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	text, entities, ok := prepareText(c, req.Text, req.ParseMode)
+	if !ok {
+		return
+	}
+
+	log.Printf("sendMessage: Calling TelegramClient.SendMessage")
+	sent, err := user.TelegramClient.SendMessage(ctx, chatID, tgclient.OutgoingMessage{
+		Text:     text,
+		Entities: entities,
+		ReplyTo:  req.ReplyTo,
+		RandomID: randomID,
+	})
+	if err != nil {
+		log.Printf("sendMessage: ERROR - Failed to send message: %v", err)
+		respondMessageError(c, "Failed to send message", err)
+		return
+	}
+
+	log.Printf("sendMessage: Successfully sent message, ID: %d, duplicate: %t", sent.ID, sent.Duplicate)
+	c.JSON(200, gin.H{
+		"status":     "sent",
+		"message_id": sent.ID,
+		"date":       sent.Date,
+		"text":       sent.Text,
+		"duplicate":  sent.Duplicate,
+		"timestamp":  time.Now(),
+	})
+}
+
+// Скільки повідомлень можна переслати або видалити одним запитом (ліміт Telegram)
+const maxMessageIDs = 100
+
+func forwardMessages(c *gin.Context) {
+	user := c.MustGet("user").(*User)
+	user.LastActivity = time.Now()
+
+	var req ForwardMessagesRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	fromChatID, err := strconv.ParseInt(req.FromChatID, 10, 64)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid from_chat_id"})
+		return
+	}
+	toChatID, err := strconv.ParseInt(req.ToChatID, 10, 64)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid to_chat_id"})
+		return
+	}
+	if !validMessageIDs(c, req.MessageIDs) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	log.Printf("forwardMessages: %d messages from %d to %d", len(req.MessageIDs), fromChatID, toChatID)
+	ids, err := user.TelegramClient.ForwardMessages(ctx, fromChatID, toChatID, req.MessageIDs, req.DropAuthor)
+	if err != nil {
+		log.Printf("forwardMessages: ERROR - Failed to forward messages: %v", err)
+		respondMessageError(c, "Failed to forward messages", err)
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"status":      "forwarded",
+		"message_ids": ids,
+		"timestamp":   time.Now(),
+	})
+}
+
+func editMessage(c *gin.Context) {
+	user := c.MustGet("user").(*User)
+	user.LastActivity = time.Now()
+
+	var req EditMessageRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	chatID, err := strconv.ParseInt(req.ChatID, 10, 64)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid chat_id"})
+		return
+	}
+	if req.MessageID <= 0 {
+		c.JSON(400, gin.H{"error": "Invalid message_id"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	text, entities, ok := prepareText(c, req.Text, req.ParseMode)
+	if !ok {
+		return
+	}
+
+	log.Printf("editMessage: Editing message %d in chat %d", req.MessageID, chatID)
+	err = user.TelegramClient.EditMessage(ctx, chatID, req.MessageID, text, entities)
+	if err != nil && tgclient.AsMessageError(err).Code != tgclient.MessageErrNotModified {
+		log.Printf("editMessage: ERROR - Failed to edit message: %v", err)
+		respondMessageError(c, "Failed to edit message", err)
+		return
+	}
+
+	status := "edited"
+	if err != nil {
+		// Текст не змінився - для клієнта це теж успіх
+		status = "not_modified"
+	}
+	c.JSON(200, gin.H{
+		"status":     status,
+		"message_id": req.MessageID,
+		"timestamp":  time.Now(),
+	})
+}
+
+func deleteMessages(c *gin.Context) {
+	user := c.MustGet("user").(*User)
+	user.LastActivity = time.Now()
+
+	var req DeleteMessagesRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	chatID, err := strconv.ParseInt(req.ChatID, 10, 64)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid chat_id"})
+		return
+	}
+	if !validMessageIDs(c, req.MessageIDs) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	log.Printf("deleteMessages: %d messages in chat %d, for everyone: %t", len(req.MessageIDs), chatID, req.ForEveryone)
+	deleted, err := user.TelegramClient.DeleteMessages(ctx, chatID, req.MessageIDs, req.ForEveryone)
+	if err != nil {
+		log.Printf("deleteMessages: ERROR - Failed to delete messages: %v", err)
+		respondMessageError(c, "Failed to delete messages", err)
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"status":      "deleted",
+		"message_ids": deleted,
+	})
+}
+
+// validMessageIDs перевіряє список ID повідомлень із запиту
+func validMessageIDs(c *gin.Context, ids []int) bool {
+	if len(ids) == 0 {
+		c.JSON(400, gin.H{"error": "No message IDs provided"})
+		return false
+	}
+	if len(ids) > maxMessageIDs {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("Too many message IDs (max %d)", maxMessageIDs)})
+		return false
+	}
+	for _, id := range ids {
+		if id <= 0 {
+			c.JSON(400, gin.H{"error": "Invalid message ID"})
+			return false
+		}
+	}
+	return true
+}
+
+// validateText перевіряє parse_mode і розмітку тексту з запиту; при помилці відповідає 400
+func validateText(c *gin.Context, raw, mode string) (tgclient.ParseMode, bool) {
+	parseMode, ok := tgclient.ParseModeByName(mode)
+	if !ok {
+		c.JSON(400, gin.H{"error": "Unsupported parse_mode (markdown, html)", "code": "invalid_parse_mode"})
+		return "", false
+	}
+
+	text, _, err := tgclient.ParseText(raw, parseMode)
+	var parseErr *tgclient.ParseError
+	if errors.As(err, &parseErr) {
+		log.Printf("validateText: ERROR - Malformed markup: %v", err)
+		c.JSON(400, gin.H{
+			"error":  fmt.Sprintf("Malformed %s: %s", parseMode, parseErr.Reason),
+			"code":   "invalid_markup",
+			"offset": parseErr.Offset,
+		})
+		return "", false
+	}
+	if strings.TrimSpace(text) == "" {
+		c.JSON(400, gin.H{"error": "Message text is empty", "code": "empty_message"})
+		return "", false
+	}
+	return parseMode, true
+}
+
+// prepareText розбирає розмітку тексту з запиту; при помилці відповідає 400
+func prepareText(c *gin.Context, raw, mode string) (string, []tg.MessageEntityClass, bool) {
+	parseMode, ok := validateText(c, raw, mode)
+	if !ok {
+		return "", nil, false
+	}
+
+	user := c.MustGet("user").(*User)
+	text, entities, err := user.TelegramClient.PrepareText(raw, parseMode)
+	if err != nil {
+		// Розмітку вже перевірено validateText
+		c.JSON(400, gin.H{"error": err.Error(), "code": "invalid_markup"})
+		return "", nil, false
+	}
+	return text, entities, true
+}
+
+// messageErrorStatus - HTTP статус для коду помилки операції з повідомленням
+func messageErrorStatus(code string) int {
+	switch code {
+	case tgclient.MessageErrNotFound, tgclient.MessageErrChatNotFound:
+		return 404
+	case tgclient.MessageErrEditForbidden, tgclient.MessageErrWriteForbidden:
+		return 403
+	case tgclient.MessageErrDuplicate, tgclient.MessageErrKeyConflict:
+		return 409
+	case tgclient.MessageErrFloodWait:
+		return 429
+	case tgclient.OutboxErrNotAuthorized:
+		return 401
+	}
+	return 500
+}
+
+// respondMessageError відповідає помилкою операції з повідомленням з машиночитним кодом
+func respondMessageError(c *gin.Context, message string, err error) {
+	messageErr := tgclient.AsMessageError(err)
+
+	response := gin.H{
+		"error": fmt.Sprintf("%s: %v", message, err),
+		"code":  messageErr.Code,
+	}
+	if messageErr.Code == tgclient.MessageErrFloodWait {
+		retryAfter := messageErr.RetryAfter()
+		response["retry_after"] = retryAfter
+		c.Header("Retry-After", strconv.Itoa(retryAfter))
+	}
+	c.JSON(messageErrorStatus(messageErr.Code), response)
+}
+
+// queueMessage ставить повідомлення в чергу відправки акаунта і одразу
+// робить першу спробу. Якщо вона не вдалася через мережу, FLOOD_WAIT
+// або Telegram недоступний, повідомлення лишається в черзі (202)
+// і шлюз надішле його сам.
+func queueMessage(c *gin.Context, user *User, chatID int64, req SendMessageRequest, randomID int64) {
+	parseMode, ok := validateText(c, req.Text, req.ParseMode)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	key := user.SessionKey
+	entry, err := connections.Enqueue(ctx, key, chatID, req.Text, parseMode, req.ReplyTo, randomID)
+	if err != nil {
+		log.Printf("queueMessage: ERROR - Failed to queue message: %v", err)
+		respondMessageError(c, "Failed to queue message", err)
+		return
+	}
+
+	if entry.Status == tgclient.OutboxPending {
+		if entry, err = connections.Deliver(ctx, key, entry.ID); err != nil {
+			log.Printf("queueMessage: ERROR - Failed to deliver message: %v", err)
+			c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to deliver message: %v", err)})
+			return
+		}
+	}
+	log.Printf("queueMessage: Message %s is %s", entry.ID, entry.Status)
+
+	entries := []tgclient.OutboxEntry{*entry}
+	presentOutbox(c, entries)
+	response := gin.H{
+		"status":     entry.Status,
+		"outbox_id":  entry.ID,
+		"message_id": entry.MessageID,
+		"outbox":     entries[0],
+	}
+	switch entry.Status {
+	case tgclient.OutboxSent:
+		c.JSON(200, response)
+	case tgclient.OutboxPending:
+		c.JSON(202, response)
+	default:
+		response["error"] = "Failed to send message: " + entry.Error
+		response["code"] = entry.Code
+		c.JSON(messageErrorStatus(entry.Code), response)
+	}
+}
+
+func getOutbox(c *gin.Context) {
+	user := c.MustGet("user").(*User)
+	user.LastActivity = time.Now()
+
+	status := c.Query("status")
+	switch status {
+	case "", tgclient.OutboxPending, tgclient.OutboxSent, tgclient.OutboxFailed:
+	default:
+		c.JSON(400, gin.H{"error": "Invalid status (pending, sent, failed)"})
+		return
+	}
+
+	entries, err := connections.OutboxEntries(c.Request.Context(), user.SessionKey)
+	if err != nil {
+		log.Printf("getOutbox: ERROR - Failed to load outbox: %v", err)
+		c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to load outbox: %v", err)})
+		return
+	}
+
+	list := []tgclient.OutboxEntry{}
+	for _, entry := range entries {
+		if status == "" || entry.Status == status {
+			list = append(list, entry)
+		}
+	}
+	presentOutbox(c, list)
+
+	c.JSON(200, gin.H{
+		"messages": list,
+		"count":    len(list),
+	})
+}
+
+func getOutboxMessage(c *gin.Context) {
+	user := c.MustGet("user").(*User)
+	user.LastActivity = time.Now()
+
+	entry, err := connections.OutboxEntry(c.Request.Context(), user.SessionKey, c.Param("id"))
+	if errors.Is(err, tgclient.ErrOutboxNotFound) {
+		c.JSON(404, gin.H{"error": "Outbox message not found", "code": "outbox_not_found"})
+		return
+	}
+	if err != nil {
+		log.Printf("getOutboxMessage: ERROR - Failed to load outbox: %v", err)
+		c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to load outbox: %v", err)})
+		return
+	}
+
+	entries := []tgclient.OutboxEntry{*entry}
+	presentOutbox(c, entries)
+	c.JSON(200, entries[0])
+}
+
+func markAsRead(c *gin.Context) {
+	user := c.MustGet("user").(*User)
+	user.LastActivity = time.Now()
+
+	var req MarkReadRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	chatID, err := strconv.ParseInt(req.ChatID, 10, 64)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid chat_id"})
+		return
+	}
+
+	if len(req.MessageIDs) == 0 {
+		c.JSON(400, gin.H{"error": "No message IDs provided"})
+		return
+	}
+
+	maxID := req.MessageIDs[0]
+	for _, id := range req.MessageIDs {
+		if id > maxID {
+			maxID = id
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := user.TelegramClient.MarkAsRead(ctx, chatID, maxID); err != nil {
+		c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to mark as read: %v", err)})
+		return
+	}
+
+	c.JSON(200, gin.H{"status": "marked_read"})
+}
+
+func getSessions(c *gin.Context) {
+	user := c.MustGet("user").(*User)
+	user.LastActivity = time.Now()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	authorizations, err := user.TelegramClient.GetAuthorizations(ctx)
+	if err != nil {
+		log.Printf("getSessions: ERROR - %v", err)
+		c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to get sessions: %v", err)})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"sessions": authorizations,
+		"count":    len(authorizations),
+	})
+}
+
+func revokeSession(c *gin.Context) {
+	user := c.MustGet("user").(*User)
+	user.LastActivity = time.Now()
+
+	var req RevokeSessionRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	hash, err := strconv.ParseInt(req.Hash, 10, 64)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid hash"})
+		return
+	}
+	if hash == 0 {
+		// Hash 0 - поточна сесія шлюзу
+		c.JSON(400, gin.H{"error": "Use /auth/logout to end the current session"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := user.TelegramClient.ResetAuthorization(ctx, hash); err != nil {
+		log.Printf("revokeSession: ERROR - %v", err)
+		c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to revoke session: %v", err)})
+		return
+	}
+
+	c.JSON(200, gin.H{"status": "revoked"})
+}
+
+func revokeOtherSessions(c *gin.Context) {
+	user := c.MustGet("user").(*User)
+	user.LastActivity = time.Now()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := user.TelegramClient.ResetOtherAuthorizations(ctx); err != nil {
+		log.Printf("revokeOtherSessions: ERROR - %v", err)
+		c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to revoke sessions: %v", err)})
+		return
+	}
+
+	c.JSON(200, gin.H{"status": "revoked"})
+}
+
+func getPhoto(c *gin.Context) {
+	// Фото відкривається як звичайне посилання, тому токен може бути в query
+	user, err := authenticate(c)
+	if errors.Is(err, errMissingAuth) {
+		user, err = authenticateQueryToken(c)
+	}
+	if err != nil {
+		abortUnauthorized(c, err)
+		return
+	}
+
+	user.LastActivity = time.Now()
+
+	chatIDStr := c.Param("chat_id")
+	messageIDStr := c.Param("message_id")
+
+	chatID, err := strconv.ParseInt(chatIDStr, 10, 64)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid chat_id"})
+		return
+	}
+
+	messageID, err := strconv.Atoi(messageIDStr)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid message_id"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	photoData, err := user.TelegramClient.GetPhotoData(ctx, messageID, chatID)
+	if err != nil {
+		log.Printf("getPhoto: ERROR - Failed to get photo: %v", err)
+		c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to get photo: %v", err)})
+		return
+	}
+
+	// Повертаємо зображення
+	c.Data(200, "image/jpeg", photoData)
+}
+
+func pollMessages(c *gin.Context) {
+	log.Printf("pollMessages: Starting request")
+
+	user := c.MustGet("user").(*User)
+	user.LastActivity = time.Now()
 
 	chatIDStr := c.Param("chat_id")
 	chatID, err := strconv.ParseInt(chatIDStr, 10, 64)
@@ -553,25 +1798,27 @@ func pollMessages(c *gin.Context) {
 	log.Printf("pollMessages: Chat ID: %d, After Message ID: %d, Timeout: %v", chatID, afterMessageID, timeout)
 
 	// Створюємо контекст з таймаутом
-	pollCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	pollCtx, cancel := context.WithTimeout(c.Request.Context(), timeout)
 	defer cancel()
 
-	// Запускаємо ticker для перевірки нових повідомлень
-	ticker := time.NewTicker(3 * time.Second)
-	defer ticker.Stop()
-
-	// Перша перевірка одразу
-	checkForNewMessages := func() ([]tgclient.Message, error) {
-		// Створюємо новий клієнт для кожної перевірки
-		client, err := tgclient.NewClientWithSession(appConfig, sessionData)
-		if err != nil {
-			return nil, err
-		}
-
-		checkCtx, checkCancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer checkCancel()
+	// Підписуємося до перевірки буфера, щоб не пропустити повідомлення між ними
+	client := user.TelegramClient
+	sub := client.Subscribe()
+	defer client.Unsubscribe(sub)
+
+	respond := func(messages []tgclient.Message) {
+		presentMessages(c, messages)
+		log.Printf("pollMessages: Found %d new messages", len(messages))
+		c.JSON(200, gin.H{
+			"has_new":  true,
+			"messages": messages,
+		})
+	}
 
-		return client.GetNewMessages(checkCtx, chatID, afterMessageID, 20)
+	// Повідомлення, які вже надійшли через оновлення
+	if messages := client.RecentMessages(chatID, afterMessageID); len(messages) > 0 {
+		respond(messages)
+		return
 	}
 
 	for {
@@ -585,58 +1832,233 @@ func pollMessages(c *gin.Context) {
 			})
 			return
 
-		case <-ticker.C:
-			// Перевіряємо нові повідомлення
-			messages, err := checkForNewMessages()
-			if err != nil {
-				log.Printf("pollMessages: ERROR - Failed to get messages: %v", err)
-				continue
-			}
-
-			if len(messages) > 0 {
-				log.Printf("pollMessages: Found %d new messages", len(messages))
+		case msg, ok := <-sub.C:
+			if !ok {
+				// З'єднання акаунта закрито - клієнт повторить запит
+				log.Printf("pollMessages: Updates stream closed")
 				c.JSON(200, gin.H{
-					"has_new":  true,
-					"messages": messages,
+					"has_new":  false,
+					"messages": []interface{}{},
 				})
 				return
 			}
+			if msg.ID <= afterMessageID || !msg.IsInChat(chatID) {
+				continue
+			}
+
+			// Забираємо решту вже отриманих повідомлень цього чату
+			messages := []tgclient.Message{msg}
+			for drained := false; !drained; {
+				select {
+				case next, ok := <-sub.C:
+					if !ok {
+						drained = true
+					} else if next.ID > afterMessageID && next.IsInChat(chatID) {
+						messages = append(messages, next)
+					}
+				default:
+					drained = true
+				}
+			}
+
+			respond(messages)
+			return
 		}
 	}
 }
 
-// MIDDLEWARE
-func authMiddleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		log.Printf("authMiddleware: Checking authorization for %s %s", c.Request.Method, c.Request.URL.Path)
+// pollUpdates - long polling усіх подій акаунта з курсором, який пристрій
+// повертає в наступному запиті
+func pollUpdates(c *gin.Context) {
+	user := c.MustGet("user").(*User)
+	user.LastActivity = time.Now()
+
+	events := user.TelegramClient.Events()
+	if events == nil {
+		c.JSON(500, gin.H{"error": "Updates are not available"})
+		return
+	}
+
+	// Без курсора - лише повідомляємо поточний, щоб пристрій почав з нього
+	cursorStr := c.Query("cursor")
+	if cursorStr == "" {
+		c.JSON(200, gin.H{
+			"cursor": events.Last(),
+			"events": []interface{}{},
+			"reset":  false,
+		})
+		return
+	}
+
+	cursor, err := strconv.ParseInt(cursorStr, 10, 64)
+	if err != nil || cursor < 0 {
+		c.JSON(400, gin.H{"error": "Invalid cursor"})
+		return
+	}
+
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+
+	timeout := 30 * time.Second
+	pollTimeout, _ := strconv.Atoi(c.DefaultQuery("timeout", "30"))
+	if pollTimeout >= 0 && pollTimeout <= 60 {
+		timeout = time.Duration(pollTimeout) * time.Second
+	}
 
-		phone := c.GetHeader("X-Phone")
-		sessionData := c.GetHeader("X-Session-Data")
+	pollCtx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+	defer cancel()
 
-		if phone == "" || sessionData == "" {
-			log.Printf("authMiddleware: ERROR - Missing required headers (X-Phone or X-Session-Data)")
-			c.JSON(401, gin.H{"error": "Missing authentication headers"})
-			c.Abort()
+	for {
+		// Канал беремо до читання журналу, щоб не пропустити подію між ними
+		changed := events.Changed()
+		list, reset := events.Since(cursor, limit)
+		if len(list) > 0 || reset {
+			next := events.Last()
+			if len(list) > 0 {
+				next = list[len(list)-1].Cursor
+			}
+			presentEvents(c, list)
+			c.JSON(200, gin.H{
+				"cursor": next,
+				"events": list,
+				"reset":  reset,
+			})
 			return
 		}
 
-		log.Printf("authMiddleware: Phone: %s", phone)
-
-		// Створюємо клієнт з session data
-		client, err := tgclient.NewClientWithSession(appConfig, sessionData)
-		if err != nil {
-			log.Printf("authMiddleware: ERROR - Failed to create client: %v", err)
-			c.JSON(401, gin.H{"error": "Invalid session data"})
-			c.Abort()
+		select {
+		case <-changed:
+		case <-pollCtx.Done():
+			c.JSON(200, gin.H{
+				"cursor": cursor,
+				"events": []interface{}{},
+				"reset":  false,
+			})
 			return
 		}
+	}
+}
+
+// CHAT IDS
+
+// legacyChatIDs повідомляє, чи потрібно віддавати клієнту сирі chat_id
+// (CHAT_ID_MODE=legacy або заголовок X-Chat-ID-Mode: legacy)
+func legacyChatIDs(c *gin.Context) bool {
+	mode := c.GetHeader("X-Chat-ID-Mode")
+	if mode == "" {
+		mode = appConfig.ChatIDMode
+	}
+	return mode == "legacy"
+}
+
+// textFormat повертає формат тексту повідомлень, запитаний клієнтом
+// (query format або header X-Text-Format); невідомий формат - простий текст
+func textFormat(c *gin.Context) tgclient.TextFormat {
+	name := c.Query("format")
+	if name == "" {
+		name = c.GetHeader("X-Text-Format")
+	}
+	format, ok := tgclient.ParseTextFormat(name)
+	if !ok {
+		log.Printf("textFormat: Unknown format %q, using plain", name)
+		return tgclient.FormatPlain
+	}
+	return format
+}
+
+// presentMessages переводить текст і chat_id повідомлень у формат клієнта
+func presentMessages(c *gin.Context, messages []tgclient.Message) {
+	format := textFormat(c)
+	legacy := legacyChatIDs(c)
+	for i := range messages {
+		messages[i] = messages[i].Formatted(format)
+		if !legacy {
+			continue
+		}
+		if id, err := strconv.ParseInt(messages[i].ChatID, 10, 64); err == nil {
+			messages[i].ChatID = strconv.FormatInt(tgclient.RawChatID(id), 10)
+		}
+		if reply := messages[i].ReplyTo; reply != nil && reply.ChatID != "" {
+			if id, err := strconv.ParseInt(reply.ChatID, 10, 64); err == nil {
+				// ReplyInfo може бути спільним з журналом подій - змінюємо копію
+				legacy := *reply
+				legacy.ChatID = strconv.FormatInt(tgclient.RawChatID(id), 10)
+				messages[i].ReplyTo = &legacy
+			}
+		}
+	}
+}
+
+// presentEvents переводить повідомлення і chat_id подій у формат клієнта
+func presentEvents(c *gin.Context, events []tgclient.Event) {
+	legacy := legacyChatIDs(c)
+	for i := range events {
+		if id, err := strconv.ParseInt(events[i].ChatID, 10, 64); legacy && err == nil {
+			events[i].ChatID = strconv.FormatInt(tgclient.RawChatID(id), 10)
+		}
+		if events[i].Message != nil {
+			// Повідомлення в журналі спільне для всіх - змінюємо копію
+			messages := []tgclient.Message{*events[i].Message}
+			presentMessages(c, messages)
+			events[i].Message = &messages[0]
+		}
+		if events[i].Outbox != nil {
+			entries := []tgclient.OutboxEntry{*events[i].Outbox}
+			presentOutbox(c, entries)
+			events[i].Outbox = &entries[0]
+		}
+	}
+}
+
+// presentOutbox переводить chat_id повідомлень черги у формат клієнта
+func presentOutbox(c *gin.Context, entries []tgclient.OutboxEntry) {
+	if !legacyChatIDs(c) {
+		return
+	}
+	for i := range entries {
+		if id, err := strconv.ParseInt(entries[i].ChatID, 10, 64); err == nil {
+			entries[i].ChatID = strconv.FormatInt(tgclient.RawChatID(id), 10)
+		}
+	}
+}
+
+// MIDDLEWARE
+
+var errMissingAuth = errors.New("missing authentication")
+
+// errStorage позначає збій сховища токенів чи пристроїв
+var errStorage = errors.New("storage error")
+
+// storageError позначає збоєм сховища будь-яку помилку, крім відомих
+// причин відмови в доступі
+func storageError(err error) error {
+	if errors.Is(err, storage.ErrNotFound) || errors.Is(err, storage.ErrTokenExpired) || errors.Is(err, storage.ErrAccountNotLinked) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", errStorage, err)
+}
+
+func authMiddleware() gin.HandlerFunc {
+	return userMiddleware(authenticate)
+}
+
+// accountMiddleware перевіряє токен, не відкриваючи з'єднання з Telegram:
+// user.TelegramClient лишається nil, доки обробник не викличе connectUser
+func accountMiddleware() gin.HandlerFunc {
+	return userMiddleware(authenticateAccount)
+}
+
+func userMiddleware(authenticate func(c *gin.Context) (*User, error)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		log.Printf("authMiddleware: Checking authorization for %s %s", c.Request.Method, c.Request.URL.Path)
 
-		// Створюємо тимчасовий об'єкт користувача для запиту
-		user := &User{
-			ID:             phone,
-			Phone:          phone,
-			TelegramClient: client,
-			LastActivity:   time.Now(),
+		user, err := authenticate(c)
+		if err != nil {
+			log.Printf("authMiddleware: ERROR - %v", err)
+			abortUnauthorized(c, err)
+			return
 		}
 
 		log.Printf("authMiddleware: User authenticated: %s", user.ID)
@@ -645,3 +2067,185 @@ func authMiddleware() gin.HandlerFunc {
 	}
 }
 
+// authenticate визначає акаунт запиту і бере його з'єднання з пулу
+func authenticate(c *gin.Context) (*User, error) {
+	user, err := authenticateAccount(c)
+	if err != nil {
+		return nil, err
+	}
+	if err := connectUser(c, user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// authenticateAccount визначає акаунт за заголовком Authorization: Bearer <token>
+// (або за X-Phone/X-Session-Data, якщо увімкнено LEGACY_SESSION_AUTH)
+// без з'єднання з Telegram
+func authenticateAccount(c *gin.Context) (*User, error) {
+	if header := c.GetHeader("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		return userFromToken(c, strings.TrimSpace(strings.TrimPrefix(header, "Bearer ")))
+	}
+
+	phone := c.GetHeader("X-Phone")
+	sessionData := c.GetHeader("X-Session-Data")
+	if appConfig.LegacySessionAuth && phone != "" && sessionData != "" {
+		return legacyUser(c, phone, sessionData)
+	}
+
+	return nil, errMissingAuth
+}
+
+// authenticateQueryToken визначає акаунт за параметром token в URL
+// і бере його з'єднання з пулу
+func authenticateQueryToken(c *gin.Context) (*User, error) {
+	token := c.Query("token")
+	if token == "" {
+		return nil, errMissingAuth
+	}
+
+	user, err := userFromToken(c, token)
+	if err != nil && appConfig.LegacySessionAuth {
+		// Старі клієнти передають base64("phone:session_data")
+		if decoded, decodeErr := base64.StdEncoding.DecodeString(token); decodeErr == nil {
+			if phone, sessionData, found := strings.Cut(string(decoded), ":"); found {
+				user, err = legacyUser(c, phone, sessionData)
+			}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := connectUser(c, user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// connectUser бере з'єднання акаунта з пулу
+func connectUser(c *gin.Context, user *User) error {
+	client, err := acquireClient(c, user.SessionKey)
+	if err != nil {
+		return err
+	}
+	user.TelegramClient = client
+	return nil
+}
+
+// userFromToken знаходить акаунт за токеном шлюзу
+func userFromToken(c *gin.Context, token string) (*User, error) {
+	info, err := tokens.Resolve(c.Request.Context(), token)
+	if err != nil {
+		return nil, storageError(err)
+	}
+
+	device, account, err := selectAccount(c, info)
+	if err != nil {
+		return nil, storageError(err)
+	}
+
+	return &User{
+		ID:           account.AccountID,
+		Phone:        account.Phone,
+		Device:       device,
+		SessionKey:   tgclient.SessionKey(account.AccountID),
+		LastActivity: time.Now(),
+	}, nil
+}
+
+// selectAccount визначає акаунт запиту: заголовок X-Account-ID або
+// параметр account_id, а без них - активний акаунт пристрою
+func selectAccount(c *gin.Context, info *storage.TokenInfo) (*storage.Device, storage.DeviceAccount, error) {
+	selected := c.GetHeader("X-Account-ID")
+	if selected == "" {
+		selected = c.Query("account_id")
+	}
+
+	if info.DeviceID == "" {
+		if selected != "" && selected != info.AccountID {
+			return nil, storage.DeviceAccount{}, storage.ErrAccountNotLinked
+		}
+		return nil, storage.DeviceAccount{AccountID: info.AccountID, Phone: info.Phone}, nil
+	}
+
+	device, err := devices.Get(c.Request.Context(), info.DeviceID)
+	if err != nil {
+		return nil, storage.DeviceAccount{}, err
+	}
+	if selected == "" {
+		selected = device.Active
+	}
+	account, ok := device.Account(selected)
+	if !ok {
+		return nil, storage.DeviceAccount{}, storage.ErrAccountNotLinked
+	}
+	return device, *account, nil
+}
+
+// legacyUser імпортує сесію, передану пристроєм
+func legacyUser(c *gin.Context, phone, sessionData string) (*User, error) {
+	key := tgclient.LegacySessionKey(phone, sessionData)
+	if !connections.Active(key) {
+		if err := tgclient.ImportSession(c.Request.Context(), sessionStore, key, sessionData); err != nil {
+			return nil, err
+		}
+	}
+
+	return &User{
+		ID:           phone,
+		Phone:        phone,
+		SessionKey:   key,
+		LastActivity: time.Now(),
+	}, nil
+}
+
+// abortUnauthorized відповідає 401 з кодом причини. Збої сховища (500)
+// і недоступність Telegram (503) не видаються за недійсний токен,
+// інакше пристрій його забуде.
+func abortUnauthorized(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, storage.ErrAccountNotLinked):
+		c.JSON(403, gin.H{"error": "Account is not linked to this device", "code": "account_not_linked"})
+	case errors.Is(err, errMissingAuth):
+		c.JSON(401, gin.H{"error": "Missing authentication", "code": "missing_token"})
+	case errors.Is(err, storage.ErrTokenExpired):
+		c.JSON(401, gin.H{"error": "Token expired", "code": "token_expired"})
+	case errors.Is(err, storage.ErrNotFound):
+		c.JSON(401, gin.H{"error": "Invalid token", "code": "invalid_token"})
+	case errors.Is(err, tgclient.ErrNotAuthorized):
+		c.JSON(401, gin.H{"error": "Telegram session is no longer authorized", "code": "session_revoked"})
+	case errors.Is(err, tgclient.ErrInvalidSession):
+		c.JSON(401, gin.H{"error": "Invalid session", "code": "invalid_session"})
+	case errors.Is(err, errStorage), errors.Is(err, tgclient.ErrSessionStore):
+		c.JSON(500, gin.H{"error": "Storage is unavailable", "code": "storage_error"})
+	default:
+		c.JSON(503, gin.H{"error": fmt.Sprintf("Telegram is unavailable: %v", err), "code": "telegram_unavailable"})
+	}
+	c.Abort()
+}
+
+// acquireClient повертає з'єднання акаунта з пулу
+func acquireClient(c *gin.Context, key string) (*tgclient.Client, error) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 30*time.Second)
+	defer cancel()
+
+	return connections.Acquire(ctx, key)
+}
+
+// runTokenCleanup періодично видаляє прострочені токени
+func runTokenCleanup(ctx context.Context) {
+	ticker := time.NewTicker(appConfig.CleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := tokens.Cleanup(ctx); err != nil {
+				log.Printf("runTokenCleanup: ERROR - %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+const devicePrefix = "device:"
+
+// ErrAccountNotLinked повертається, якщо акаунт не прив'язаний до пристрою
+var ErrAccountNotLinked = errors.New("account is not linked to device")
+
+// DeviceAccount - акаунт Telegram, прив'язаний до пристрою
+type DeviceAccount struct {
+	AccountID string    `json:"account_id"`
+	Phone     string    `json:"phone"`
+	LinkedAt  time.Time `json:"linked_at"`
+}
+
+// Device - профіль пристрою, якому належить один або кілька акаунтів.
+// Токени шлюзу видаються пристрою, а акаунт обирається в кожному запиті.
+type Device struct {
+	ID        string          `json:"id"`
+	Accounts  []DeviceAccount `json:"accounts"`
+	Active    string          `json:"active"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// Account шукає прив'язаний акаунт
+func (d *Device) Account(accountID string) (*DeviceAccount, bool) {
+	for i := range d.Accounts {
+		if d.Accounts[i].AccountID == accountID {
+			return &d.Accounts[i], true
+		}
+	}
+	return nil, false
+}
+
+// Devices зберігає профілі пристроїв у сховищі сесій
+type Devices struct {
+	store SessionStore
+	mu    sync.Mutex
+}
+
+// NewDevices створює сховище профілів пристроїв
+func NewDevices(store SessionStore) *Devices {
+	return &Devices{store: store}
+}
+
+// Create створює новий порожній профіль пристрою
+func (d *Devices) Create(ctx context.Context) (*Device, error) {
+	id, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+
+	device := &Device{ID: id, CreatedAt: time.Now()}
+	if err := d.save(ctx, device); err != nil {
+		return nil, err
+	}
+	return device, nil
+}
+
+// Get повертає профіль пристрою
+func (d *Devices) Get(ctx context.Context, id string) (*Device, error) {
+	data, err := d.store.Load(ctx, devicePrefix+id)
+	if err != nil {
+		return nil, err
+	}
+	var device Device
+	if err := json.Unmarshal(data, &device); err != nil {
+		return nil, err
+	}
+	return &device, nil
+}
+
+// Link прив'язує акаунт до пристрою і робить його активним
+func (d *Devices) Link(ctx context.Context, id, accountID, phone string) (*Device, error) {
+	return d.update(ctx, id, func(device *Device) error {
+		if account, ok := device.Account(accountID); ok {
+			account.Phone = phone
+		} else {
+			device.Accounts = append(device.Accounts, DeviceAccount{
+				AccountID: accountID,
+				Phone:     phone,
+				LinkedAt:  time.Now(),
+			})
+		}
+		device.Active = accountID
+		return nil
+	})
+}
+
+// SetActive робить акаунт активним (використовується без явного вибору акаунта)
+func (d *Devices) SetActive(ctx context.Context, id, accountID string) (*Device, error) {
+	return d.update(ctx, id, func(device *Device) error {
+		if _, ok := device.Account(accountID); !ok {
+			return ErrAccountNotLinked
+		}
+		device.Active = accountID
+		return nil
+	})
+}
+
+// Touch оновлює час зміни профілю, щоб його не прибрало очищення сховища
+func (d *Devices) Touch(ctx context.Context, id string) error {
+	_, err := d.update(ctx, id, func(*Device) error { return nil })
+	return err
+}
+
+// Unlink відв'язує акаунт від усіх пристроїв. Пристрої, в яких
+// не лишилось акаунтів, видаляються; повертаються їхні ID.
+func (d *Devices) Unlink(ctx context.Context, accountID string) ([]string, error) {
+	entries, err := d.store.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var emptied []string
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Key, devicePrefix) {
+			continue
+		}
+		id := strings.TrimPrefix(entry.Key, devicePrefix)
+
+		device, err := d.update(ctx, id, func(device *Device) error {
+			accounts := device.Accounts[:0]
+			for _, account := range device.Accounts {
+				if account.AccountID != accountID {
+					accounts = append(accounts, account)
+				}
+			}
+			device.Accounts = accounts
+			if device.Active == accountID {
+				device.Active = ""
+				if len(accounts) > 0 {
+					device.Active = accounts[0].AccountID
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			log.Printf("Devices: Failed to unlink %s from %s: %v", accountID, id, err)
+			continue
+		}
+
+		if len(device.Accounts) == 0 {
+			if err := d.store.Delete(ctx, entry.Key); err != nil {
+				log.Printf("Devices: Failed to delete %s: %v", id, err)
+			}
+			emptied = append(emptied, id)
+		}
+	}
+	return emptied, nil
+}
+
+// update атомарно змінює профіль пристрою
+func (d *Devices) update(ctx context.Context, id string, fn func(device *Device) error) (*Device, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	device, err := d.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := fn(device); err != nil {
+		return nil, err
+	}
+	if err := d.save(ctx, device); err != nil {
+		return nil, err
+	}
+	return device, nil
+}
+
+func (d *Devices) save(ctx context.Context, device *Device) error {
+	data, err := json.Marshal(device)
+	if err != nil {
+		return err
+	}
+	return d.store.Save(ctx, devicePrefix+device.ID, data)
+}
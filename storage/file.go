@@ -0,0 +1,146 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const sessionFileExt = ".session"
+
+// FileStore зберігає зашифровані сесії у файлах каталогу.
+// Ім'я файлу - хеш ключа, тож ні ключ, ні дані не лежать на диску відкрито.
+type FileStore struct {
+	dir    string
+	sealer *sealer
+}
+
+type fileRecord struct {
+	Key  string `json:"key"`
+	Data []byte `json:"data"`
+}
+
+// NewFileStore створює файлове сховище в каталозі dir
+func NewFileStore(dir, secret string) (*FileStore, error) {
+	if secret == "" {
+		return nil, fmt.Errorf("SESSION_ENCRYPTION_KEY must be set for file session store")
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create session dir: %w", err)
+	}
+
+	s, err := newSealer(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileStore{dir: dir, sealer: s}, nil
+}
+
+func (s *FileStore) fileName(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:]) + sessionFileExt
+}
+
+func (s *FileStore) read(name string) (*fileRecord, error) {
+	sealed, err := os.ReadFile(filepath.Join(s.dir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := s.sealer.open(name, sealed)
+	if err != nil {
+		return nil, err
+	}
+
+	var record fileRecord
+	if err := json.Unmarshal(payload, &record); err != nil {
+		return nil, fmt.Errorf("failed to decode session file: %w", err)
+	}
+	return &record, nil
+}
+
+func (s *FileStore) Load(_ context.Context, key string) ([]byte, error) {
+	record, err := s.read(s.fileName(key))
+	if err != nil {
+		return nil, err
+	}
+	return record.Data, nil
+}
+
+func (s *FileStore) Save(_ context.Context, key string, data []byte) error {
+	name := s.fileName(key)
+
+	payload, err := json.Marshal(fileRecord{Key: key, Data: data})
+	if err != nil {
+		return err
+	}
+	sealed, err := s.sealer.seal(name, payload)
+	if err != nil {
+		return err
+	}
+
+	// Пишемо у тимчасовий файл і перейменовуємо, щоб не лишити обрізаний файл
+	tmp, err := os.CreateTemp(s.dir, name+".tmp*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(sealed); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(s.dir, name))
+}
+
+func (s *FileStore) Delete(_ context.Context, key string) error {
+	err := os.Remove(filepath.Join(s.dir, s.fileName(key)))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (s *FileStore) List(_ context.Context) ([]Entry, error) {
+	files, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []Entry
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), sessionFileExt) {
+			continue
+		}
+
+		info, err := file.Info()
+		if err != nil {
+			continue
+		}
+		record, err := s.read(file.Name())
+		if err != nil {
+			// Пошкоджений або чужий файл - пропускаємо
+			continue
+		}
+
+		entries = append(entries, Entry{Key: record.Key, UpdatedAt: info.ModTime()})
+	}
+	return entries, nil
+}
+
+func (s *FileStore) Close() error {
+	return nil
+}
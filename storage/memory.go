@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type memoryRecord struct {
+	data      []byte
+	updatedAt time.Time
+}
+
+// MemoryStore зберігає сесії в пам'яті процесу (втрачаються при перезапуску)
+type MemoryStore struct {
+	mu      sync.RWMutex
+	records map[string]memoryRecord
+}
+
+// NewMemoryStore створює сховище в пам'яті
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{records: make(map[string]memoryRecord)}
+}
+
+func (s *MemoryStore) Load(_ context.Context, key string) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	record, exists := s.records[key]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	return append([]byte(nil), record.data...), nil
+}
+
+func (s *MemoryStore) Save(_ context.Context, key string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.records[key] = memoryRecord{
+		data:      append([]byte(nil), data...),
+		updatedAt: time.Now(),
+	}
+	return nil
+}
+
+func (s *MemoryStore) Delete(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.records, key)
+	return nil
+}
+
+func (s *MemoryStore) List(_ context.Context) ([]Entry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entries := make([]Entry, 0, len(s.records))
+	for key, record := range s.records {
+		entries = append(entries, Entry{Key: key, UpdatedAt: record.updatedAt})
+	}
+	return entries, nil
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
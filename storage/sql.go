@@ -0,0 +1,115 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"telegram-gateway/config"
+
+	_ "github.com/lib/pq"
+)
+
+// SQLStore зберігає сесії в PostgreSQL (DB_HOST, DB_NAME, DB_USER ...).
+// Якщо задано SESSION_ENCRYPTION_KEY, дані шифруються перед записом.
+type SQLStore struct {
+	db     *sql.DB
+	sealer *sealer
+}
+
+const sqlSchema = `
+CREATE TABLE IF NOT EXISTS gateway_sessions (
+	key        TEXT PRIMARY KEY,
+	data       BYTEA NOT NULL,
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+)`
+
+// NewSQLStore підключається до бази і створює таблицю сесій
+func NewSQLStore(cfg *config.Config) (*SQLStore, error) {
+	dsn := fmt.Sprintf("host=%s port=%s dbname=%s user=%s password=%s sslmode=%s",
+		cfg.DBHost, cfg.DBPort, cfg.DBName, cfg.DBUser, cfg.DBPassword, cfg.DBSSLMode)
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	ctx := context.Background()
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	if _, err := db.ExecContext(ctx, sqlSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create sessions table: %w", err)
+	}
+
+	store := &SQLStore{db: db}
+	if cfg.SessionEncryptionKey != "" {
+		if store.sealer, err = newSealer(cfg.SessionEncryptionKey); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
+	return store, nil
+}
+
+func (s *SQLStore) Load(ctx context.Context, key string) ([]byte, error) {
+	var data []byte
+	err := s.db.QueryRowContext(ctx, `SELECT data FROM gateway_sessions WHERE key = $1`, key).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if s.sealer != nil {
+		return s.sealer.open(key, data)
+	}
+	return data, nil
+}
+
+func (s *SQLStore) Save(ctx context.Context, key string, data []byte) error {
+	if s.sealer != nil {
+		sealed, err := s.sealer.seal(key, data)
+		if err != nil {
+			return err
+		}
+		data = sealed
+	}
+
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO gateway_sessions (key, data, updated_at) VALUES ($1, $2, now())
+		ON CONFLICT (key) DO UPDATE SET data = EXCLUDED.data, updated_at = now()`,
+		key, data)
+	return err
+}
+
+func (s *SQLStore) Delete(ctx context.Context, key string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM gateway_sessions WHERE key = $1`, key)
+	return err
+}
+
+func (s *SQLStore) List(ctx context.Context) ([]Entry, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT key, updated_at FROM gateway_sessions`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []Entry
+	for rows.Next() {
+		var entry Entry
+		if err := rows.Scan(&entry.Key, &entry.UpdatedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+func (s *SQLStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"telegram-gateway/config"
+	"time"
+)
+
+// ErrNotFound повертається, якщо запису з таким ключем немає
+var ErrNotFound = errors.New("session not found")
+
+// Entry описує збережений запис
+type Entry struct {
+	Key       string
+	UpdatedAt time.Time
+}
+
+// SessionStore зберігає MTProto сесії акаунтів за ключем
+type SessionStore interface {
+	// Load повертає дані за ключем або ErrNotFound
+	Load(ctx context.Context, key string) ([]byte, error)
+	// Save створює або перезаписує дані за ключем
+	Save(ctx context.Context, key string, data []byte) error
+	// Delete видаляє дані за ключем (відсутній ключ - не помилка)
+	Delete(ctx context.Context, key string) error
+	// List повертає всі ключі з часом останнього оновлення
+	List(ctx context.Context) ([]Entry, error)
+	// Close звільняє ресурси сховища
+	Close() error
+}
+
+// Open створює сховище сесій відповідно до конфігурації (SESSION_STORE)
+func Open(cfg *config.Config) (SessionStore, error) {
+	switch cfg.SessionStore {
+	case "", "memory":
+		return NewMemoryStore(), nil
+	case "file":
+		return NewFileStore(cfg.SessionDir, cfg.SessionEncryptionKey)
+	case "sql":
+		return NewSQLStore(cfg)
+	default:
+		return nil, fmt.Errorf("unknown session store: %s", cfg.SessionStore)
+	}
+}
+
+// Cleanup видаляє записи з ключами, що починаються з одного з prefixes
+// і не оновлювались довше maxAge, і повертає їхні ключі.
+// Ключі, для яких keep повертає true, не видаляються.
+func Cleanup(ctx context.Context, store SessionStore, prefixes []string, maxAge time.Duration, keep func(key string) bool) ([]string, error) {
+	entries, err := store.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(-maxAge)
+	var removed []string
+	for _, entry := range entries {
+		if !hasAnyPrefix(entry.Key, prefixes) || !entry.UpdatedAt.Before(deadline) {
+			continue
+		}
+		if keep != nil && keep(entry.Key) {
+			continue
+		}
+		if err := store.Delete(ctx, entry.Key); err != nil {
+			log.Printf("Storage: Failed to delete expired session %s: %v", entry.Key, err)
+			continue
+		}
+		removed = append(removed, entry.Key)
+	}
+
+	return removed, nil
+}
+
+func hasAnyPrefix(key string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,229 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"time"
+)
+
+const (
+	accessTokenPrefix  = "token:"
+	refreshTokenPrefix = "refresh:"
+)
+
+// ErrTokenExpired повертається для токена, строк дії якого минув
+var ErrTokenExpired = errors.New("token expired")
+
+// TokenInfo описує пристрій і акаунт, для яких видано токен
+type TokenInfo struct {
+	// Порожній для токенів, виданих до появи профілів пристроїв
+	DeviceID  string    `json:"device_id,omitempty"`
+	AccountID string    `json:"account_id"`
+	Phone     string    `json:"phone"`
+	ExpiresAt time.Time `json:"expires_at"`
+	// Хеш парного токена (access <-> refresh)
+	Pair string `json:"pair"`
+}
+
+// TokenPair - пара токенів, що віддається клієнту
+type TokenPair struct {
+	AccessToken  string
+	RefreshToken string
+	ExpiresIn    time.Duration
+}
+
+// Tokens видає і перевіряє непрозорі токени шлюзу.
+// В сховищі лежать лише хеші токенів, самі токени знає тільки пристрій.
+type Tokens struct {
+	store      SessionStore
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+}
+
+// NewTokens створює сервіс токенів поверх сховища сесій
+func NewTokens(store SessionStore, accessTTL, refreshTTL time.Duration) *Tokens {
+	return &Tokens{
+		store:      store,
+		accessTTL:  accessTTL,
+		refreshTTL: refreshTTL,
+	}
+}
+
+func newToken() (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func (t *Tokens) save(ctx context.Context, key string, info TokenInfo) error {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	return t.store.Save(ctx, key, data)
+}
+
+func (t *Tokens) load(ctx context.Context, key string) (*TokenInfo, error) {
+	data, err := t.store.Load(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	var info TokenInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// Issue видає нову пару токенів пристрою; accountID - акаунт, з яким виконано вхід
+func (t *Tokens) Issue(ctx context.Context, deviceID, accountID, phone string) (*TokenPair, error) {
+	access, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+	refresh, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	accessHash, refreshHash := hashToken(access), hashToken(refresh)
+
+	if err := t.save(ctx, refreshTokenPrefix+refreshHash, TokenInfo{
+		DeviceID:  deviceID,
+		AccountID: accountID,
+		Phone:     phone,
+		ExpiresAt: now.Add(t.refreshTTL),
+		Pair:      accessHash,
+	}); err != nil {
+		return nil, err
+	}
+	if err := t.save(ctx, accessTokenPrefix+accessHash, TokenInfo{
+		DeviceID:  deviceID,
+		AccountID: accountID,
+		Phone:     phone,
+		ExpiresAt: now.Add(t.accessTTL),
+		Pair:      refreshHash,
+	}); err != nil {
+		return nil, err
+	}
+
+	return &TokenPair{
+		AccessToken:  access,
+		RefreshToken: refresh,
+		ExpiresIn:    t.accessTTL,
+	}, nil
+}
+
+// Resolve повертає акаунт за access токеном
+func (t *Tokens) Resolve(ctx context.Context, token string) (*TokenInfo, error) {
+	if token == "" {
+		return nil, ErrNotFound
+	}
+	info, err := t.load(ctx, accessTokenPrefix+hashToken(token))
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().After(info.ExpiresAt) {
+		return nil, ErrTokenExpired
+	}
+	return info, nil
+}
+
+// Refresh обмінює refresh токен на нову пару; старі токени відкликаються
+func (t *Tokens) Refresh(ctx context.Context, refreshToken string) (*TokenPair, *TokenInfo, error) {
+	key := refreshTokenPrefix + hashToken(refreshToken)
+	info, err := t.load(ctx, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Refresh токен одноразовий
+	t.store.Delete(ctx, key)
+	t.store.Delete(ctx, accessTokenPrefix+info.Pair)
+
+	if time.Now().After(info.ExpiresAt) {
+		return nil, nil, ErrTokenExpired
+	}
+
+	pair, err := t.Issue(ctx, info.DeviceID, info.AccountID, info.Phone)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pair, info, nil
+}
+
+// Revoke відкликає access токен разом з його refresh токеном
+func (t *Tokens) Revoke(ctx context.Context, token string) error {
+	key := accessTokenPrefix + hashToken(token)
+	info, err := t.load(ctx, key)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := t.store.Delete(ctx, refreshTokenPrefix+info.Pair); err != nil {
+		return err
+	}
+	return t.store.Delete(ctx, key)
+}
+
+// RevokeAccount відкликає токени акаунта, видані без профілю пристрою
+func (t *Tokens) RevokeAccount(ctx context.Context, accountID string) error {
+	return t.sweep(ctx, func(info *TokenInfo) bool {
+		return info.DeviceID == "" && info.AccountID == accountID
+	})
+}
+
+// RevokeDevice відкликає всі токени пристрою
+func (t *Tokens) RevokeDevice(ctx context.Context, deviceID string) error {
+	return t.sweep(ctx, func(info *TokenInfo) bool {
+		return info.DeviceID == deviceID
+	})
+}
+
+// Cleanup видаляє прострочені токени
+func (t *Tokens) Cleanup(ctx context.Context) error {
+	now := time.Now()
+	return t.sweep(ctx, func(info *TokenInfo) bool {
+		return now.After(info.ExpiresAt)
+	})
+}
+
+// sweep видаляє всі токени, для яких match повертає true
+func (t *Tokens) sweep(ctx context.Context, match func(info *TokenInfo) bool) error {
+	entries, err := t.store.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Key, accessTokenPrefix) && !strings.HasPrefix(entry.Key, refreshTokenPrefix) {
+			continue
+		}
+		info, err := t.load(ctx, entry.Key)
+		if err != nil || !match(info) {
+			continue
+		}
+		if err := t.store.Delete(ctx, entry.Key); err != nil {
+			log.Printf("Tokens: Failed to delete %s: %v", entry.Key, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Authorization - сесія акаунта на одному з пристроїв
+type Authorization struct {
+	Hash          string    `json:"hash"`
+	Current       bool      `json:"current"`
+	Device        string    `json:"device"`
+	Platform      string    `json:"platform"`
+	SystemVersion string    `json:"system_version"`
+	AppName       string    `json:"app_name"`
+	AppVersion    string    `json:"app_version"`
+	OfficialApp   bool      `json:"official_app"`
+	IP            string    `json:"ip"`
+	Country       string    `json:"country"`
+	Region        string    `json:"region"`
+	Created       time.Time `json:"created"`
+	LastActive    time.Time `json:"last_active"`
+}
+
+// LogOut завершує сесію шлюзу на боці Telegram
+func (c *Client) LogOut(ctx context.Context) error {
+	return c.run(ctx, func(ctx context.Context) error {
+		if _, err := c.Client.API().AuthLogOut(ctx); err != nil {
+			return fmt.Errorf("log out error: %w", err)
+		}
+		return nil
+	})
+}
+
+// GetAuthorizations повертає всі активні сесії акаунта
+func (c *Client) GetAuthorizations(ctx context.Context) ([]Authorization, error) {
+	var authorizations []Authorization
+
+	err := c.run(ctx, func(ctx context.Context) error {
+		result, err := c.Client.API().AccountGetAuthorizations(ctx)
+		if err != nil {
+			return fmt.Errorf("get authorizations error: %w", err)
+		}
+
+		for _, a := range result.Authorizations {
+			authorizations = append(authorizations, Authorization{
+				Hash:          strconv.FormatInt(a.Hash, 10),
+				Current:       a.Current,
+				Device:        a.DeviceModel,
+				Platform:      a.Platform,
+				SystemVersion: a.SystemVersion,
+				AppName:       a.AppName,
+				AppVersion:    a.AppVersion,
+				OfficialApp:   a.OfficialApp,
+				IP:            a.IP,
+				Country:       a.Country,
+				Region:        a.Region,
+				Created:       time.Unix(int64(a.DateCreated), 0),
+				LastActive:    time.Unix(int64(a.DateActive), 0),
+			})
+		}
+		return nil
+	})
+
+	return authorizations, err
+}
+
+// ResetAuthorization завершує одну сесію акаунта за її hash
+func (c *Client) ResetAuthorization(ctx context.Context, hash int64) error {
+	return c.run(ctx, func(ctx context.Context) error {
+		if _, err := c.Client.API().AccountResetAuthorization(ctx, hash); err != nil {
+			return fmt.Errorf("reset authorization error: %w", err)
+		}
+		return nil
+	})
+}
+
+// ResetOtherAuthorizations завершує всі сесії акаунта, крім поточної
+func (c *Client) ResetOtherAuthorizations(ctx context.Context) error {
+	return c.run(ctx, func(ctx context.Context) error {
+		if _, err := c.Client.API().AuthResetAuthorizations(ctx); err != nil {
+			return fmt.Errorf("reset authorizations error: %w", err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,275 @@
+package telegram
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
+)
+
+// Стабільні коди помилок операцій з повідомленнями
+const (
+	MessageErrNotFound       = "message_not_found"
+	MessageErrNotModified    = "message_not_modified"
+	MessageErrEditForbidden  = "edit_forbidden"
+	MessageErrWriteForbidden = "write_forbidden"
+	MessageErrFloodWait      = "flood_wait"
+	MessageErrDuplicate      = "duplicate_message"
+	MessageErrKeyConflict    = "idempotency_conflict"
+	MessageErrChatNotFound   = "chat_not_found"
+	MessageErrFailed         = "message_failed"
+)
+
+// MessageError - помилка Telegram під час операції з повідомленням
+type MessageError struct {
+	Code  string
+	Until time.Time // до якого часу діє FLOOD_WAIT
+	Err   error
+}
+
+func (e *MessageError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *MessageError) Unwrap() error {
+	return e.Err
+}
+
+// RetryAfter повертає, скільки секунд лишилось чекати після FLOOD_WAIT
+func (e *MessageError) RetryAfter() int {
+	remaining := time.Until(e.Until)
+	if remaining <= 0 {
+		return 0
+	}
+	return int((remaining + time.Second - 1) / time.Second)
+}
+
+// AsMessageError перетворює помилку RPC на MessageError
+func AsMessageError(err error) *MessageError {
+	var messageErr *MessageError
+	if errors.As(err, &messageErr) {
+		return messageErr
+	}
+
+	result := &MessageError{Code: MessageErrFailed, Err: err}
+	if errors.Is(err, ErrPeerNotFound) {
+		result.Code = MessageErrChatNotFound
+		return result
+	}
+	if wait, ok := tgerr.AsFloodWait(err); ok {
+		result.Code = MessageErrFloodWait
+		result.Until = time.Now().Add(wait)
+		return result
+	}
+
+	switch {
+	case tgerr.Is(err, "MESSAGE_ID_INVALID", "MESSAGE_IDS_EMPTY", "REPLY_MESSAGE_ID_INVALID"):
+		result.Code = MessageErrNotFound
+	case tgerr.Is(err, "MESSAGE_NOT_MODIFIED"):
+		result.Code = MessageErrNotModified
+	case tgerr.Is(err, "MESSAGE_AUTHOR_REQUIRED", "MESSAGE_EDIT_TIME_EXPIRED", "MESSAGE_DELETE_FORBIDDEN"):
+		result.Code = MessageErrEditForbidden
+	case tgerr.Is(err, "CHAT_WRITE_FORBIDDEN", "CHAT_ADMIN_REQUIRED", "USER_BANNED_IN_CHANNEL",
+		"CHAT_SEND_PLAIN_FORBIDDEN", "CHAT_FORWARDS_RESTRICTED", "CHANNEL_PRIVATE", "USER_IS_BLOCKED"):
+		result.Code = MessageErrWriteForbidden
+	}
+	return result
+}
+
+// newRandomID генерує random_id для надсилання повідомлення
+func newRandomID() int64 {
+	var buf [8]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return time.Now().UnixNano()
+	}
+	return int64(binary.LittleEndian.Uint64(buf[:]))
+}
+
+// sentMessageIDs повертає ID нових повідомлень за їх random_id
+func sentMessageIDs(updates tg.UpdatesClass) map[int64]int {
+	ids := make(map[int64]int)
+	for _, update := range updatesList(updates) {
+		if sent, ok := update.(*tg.UpdateMessageID); ok {
+			ids[sent.RandomID] = sent.ID
+		}
+	}
+	return ids
+}
+
+// ForwardMessages пересилає повідомлення ids з чату fromChatID у чат toChatID.
+// dropAuthor - переслати без підпису автора (як копію).
+// Повертає ID нових повідомлень у тому ж порядку (0 - не переслано).
+func (c *Client) ForwardMessages(ctx context.Context, fromChatID, toChatID int64, ids []int, dropAuthor bool) ([]int, error) {
+	forwarded := make([]int, len(ids))
+
+	err := c.run(ctx, func(ctx context.Context) error {
+		api := c.Client.API()
+
+		fromPeer, err := c.GetInputPeer(ctx, fromChatID)
+		if err != nil {
+			return fmt.Errorf("get input peer error: %w", err)
+		}
+		toPeer, err := c.GetInputPeer(ctx, toChatID)
+		if err != nil {
+			return fmt.Errorf("get input peer error: %w", err)
+		}
+
+		randomIDs := make([]int64, len(ids))
+		for i := range randomIDs {
+			randomIDs[i] = newRandomID()
+		}
+
+		updates, err := api.MessagesForwardMessages(ctx, &tg.MessagesForwardMessagesRequest{
+			FromPeer:   fromPeer,
+			ID:         ids,
+			RandomID:   randomIDs,
+			ToPeer:     toPeer,
+			DropAuthor: dropAuthor,
+		})
+		if err != nil {
+			return fmt.Errorf("forward messages error: %w", err)
+		}
+
+		sent := sentMessageIDs(updates)
+		for i, randomID := range randomIDs {
+			forwarded[i] = sent[randomID]
+		}
+		c.handleUpdates(ctx, "ForwardMessages", updates)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return forwarded, nil
+}
+
+// EditMessage змінює текст власного повідомлення
+func (c *Client) EditMessage(ctx context.Context, chatID int64, messageID int, text string, entities []tg.MessageEntityClass) error {
+	return c.run(ctx, func(ctx context.Context) error {
+		peer, err := c.GetInputPeer(ctx, chatID)
+		if err != nil {
+			return fmt.Errorf("get input peer error: %w", err)
+		}
+
+		request := &tg.MessagesEditMessageRequest{
+			Peer: peer,
+			ID:   messageID,
+		}
+		request.SetMessage(text)
+		// Порожній список теж передаємо - інакше старе форматування залишиться
+		request.SetEntities(entities)
+
+		updates, err := c.Client.API().MessagesEditMessage(ctx, request)
+		if err != nil {
+			return fmt.Errorf("edit message error: %w", err)
+		}
+		c.handleUpdates(ctx, "EditMessage", updates)
+		return nil
+	})
+}
+
+// DeleteMessages видаляє повідомлення чату і повертає ID видалених.
+// forEveryone - видалити і в співрозмовників; у каналах і супергрупах
+// повідомлення видаляються для всіх завжди.
+func (c *Client) DeleteMessages(ctx context.Context, chatID int64, ids []int, forEveryone bool) ([]int, error) {
+	err := c.run(ctx, func(ctx context.Context) error {
+		api := c.Client.API()
+
+		peer, err := c.GetInputPeer(ctx, chatID)
+		if err != nil {
+			return fmt.Errorf("get input peer error: %w", err)
+		}
+
+		if channel, ok := InputChannel(peer); ok {
+			affected, err := api.ChannelsDeleteMessages(ctx, &tg.ChannelsDeleteMessagesRequest{
+				Channel: channel,
+				ID:      ids,
+			})
+			if err != nil {
+				return fmt.Errorf("delete messages error: %w", err)
+			}
+			c.handleUpdates(ctx, "DeleteMessages", &tg.UpdateShort{
+				Update: &tg.UpdateDeleteChannelMessages{
+					ChannelID: channel.ChannelID,
+					Messages:  ids,
+					Pts:       affected.Pts,
+					PtsCount:  affected.PtsCount,
+				},
+				Date: int(time.Now().Unix()),
+			})
+			return nil
+		}
+
+		// ID в особистих чатах і групах спільні для всього акаунта -
+		// видаляємо лише ті, що справді належать чату chatID
+		ids, err = c.chatMessageIDs(ctx, chatID, ids)
+		if err != nil {
+			return err
+		}
+		affected, err := api.MessagesDeleteMessages(ctx, &tg.MessagesDeleteMessagesRequest{
+			Revoke: forEveryone,
+			ID:     ids,
+		})
+		if err != nil {
+			return fmt.Errorf("delete messages error: %w", err)
+		}
+		c.handleUpdates(ctx, "DeleteMessages", &tg.UpdateShort{
+			Update: &tg.UpdateDeleteMessages{
+				Messages: ids,
+				Pts:      affected.Pts,
+				PtsCount: affected.PtsCount,
+			},
+			Date: int(time.Now().Unix()),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// handleUpdates передає відповідь на RPC менеджеру оновлень, щоб не зсувався pts
+// і власні дії акаунта одразу потрапили в журнал подій
+func (c *Client) handleUpdates(ctx context.Context, op string, updates tg.UpdatesClass) {
+	if err := c.gaps.Handle(ctx, updates); err != nil {
+		log.Printf("%s: Failed to handle updates: %v", op, err)
+	}
+}
+
+// chatMessageIDs залишає з ids лише повідомлення чату chatID (не для каналів)
+func (c *Client) chatMessageIDs(ctx context.Context, chatID int64, ids []int) ([]int, error) {
+	input := make([]tg.InputMessageClass, len(ids))
+	for i, id := range ids {
+		input[i] = &tg.InputMessageID{ID: id}
+	}
+
+	result, err := c.Client.API().MessagesGetMessages(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("get messages error: %w", err)
+	}
+	modified, ok := result.AsModified()
+	if !ok {
+		return nil, fmt.Errorf("unexpected messages type: %T", result)
+	}
+
+	var owned []int
+	for _, m := range modified.GetMessages() {
+		if _, empty := m.(*tg.MessageEmpty); empty {
+			continue
+		}
+		if SameChat(MarkedPeerID(messagePeer(m)), chatID) {
+			owned = append(owned, m.GetID())
+		}
+	}
+	if len(owned) == 0 {
+		return nil, &MessageError{Code: MessageErrNotFound, Err: fmt.Errorf("no messages of chat %d among %v", chatID, ids)}
+	}
+	return owned, nil
+}
@@ -2,132 +2,357 @@ package telegram
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"time"
 
+	"github.com/gotd/td/telegram"
 	"github.com/gotd/td/telegram/auth"
+	"github.com/gotd/td/telegram/auth/qrlogin"
 	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
 )
 
-var (
-	pendingAuth        = make(map[string]*PendingAuth)
-	authCodeChannel    = make(map[string]chan string)
-	authPasswordChannel = make(map[string]chan string)
+// Стабільні коди помилок входу для клієнтів
+const (
+	AuthErrCodeInvalid     = "code_invalid"
+	AuthErrCodeExpired     = "code_expired"
+	AuthErrPasswordInvalid = "password_invalid"
+	AuthErrPhoneInvalid    = "phone_invalid"
+	AuthErrFloodWait       = "flood_wait"
+	AuthErrNameInvalid     = "name_invalid"
+	AuthErrFailed          = "auth_failed"
 )
 
-type PendingAuth struct {
-	Phone           string
-	PhoneHash       string
-	NeedsPassword   bool
+// AuthError - помилка Telegram під час входу з машиночитним кодом
+type AuthError struct {
+	Code  string
+	Until time.Time // до якого часу діє FLOOD_WAIT
+	Err   error
 }
 
-type AuthHandler struct {
-	PhoneNumber string
+func (e *AuthError) Error() string {
+	return e.Err.Error()
 }
 
-// Phone повертає номер телефону
-func (a AuthHandler) Phone(_ context.Context) (string, error) {
-	return a.PhoneNumber, nil
+func (e *AuthError) Unwrap() error {
+	return e.Err
 }
 
-// Password повертає пароль (якщо потрібен)
-func (a AuthHandler) Password(_ context.Context) (string, error) {
-	log.Printf("2FA Password requested for: %s", a.PhoneNumber)
+// RetryAfter повертає, скільки секунд лишилось чекати після FLOOD_WAIT
+func (e *AuthError) RetryAfter() int {
+	remaining := time.Until(e.Until)
+	if remaining <= 0 {
+		return 0
+	}
+	return int((remaining + time.Second - 1) / time.Second)
+}
 
-	// Встановлюємо прапорець, що потрібен пароль
-	auth, exists := pendingAuth[a.PhoneNumber]
-	if !exists {
-		auth = &PendingAuth{
-			Phone:         a.PhoneNumber,
-			NeedsPassword: true,
-		}
-		pendingAuth[a.PhoneNumber] = auth
-	} else {
-		auth.NeedsPassword = true
+// Retryable повідомляє, чи можна повторити введення в тій самій спробі
+func (e *AuthError) Retryable() bool {
+	switch e.Code {
+	case AuthErrCodeInvalid, AuthErrCodeExpired, AuthErrPasswordInvalid, AuthErrFloodWait, AuthErrNameInvalid:
+		return true
+	}
+	return false
+}
+
+// AsAuthError перетворює помилку RPC на AuthError
+func AsAuthError(err error) *AuthError {
+	var authErr *AuthError
+	if errors.As(err, &authErr) {
+		return authErr
 	}
 
-	log.Printf("Set NeedsPassword=true for: %s", a.PhoneNumber)
+	result := &AuthError{Code: AuthErrFailed, Err: err}
+	if wait, ok := tgerr.AsFloodWait(err); ok {
+		result.Code = AuthErrFloodWait
+		result.Until = time.Now().Add(wait)
+		return result
+	}
 
-	// Чекаємо на пароль з API endpoint
-	ch, exists := authPasswordChannel[a.PhoneNumber]
-	if !exists {
-		ch = make(chan string, 1)
-		authPasswordChannel[a.PhoneNumber] = ch
+	switch {
+	case tgerr.Is(err, "PHONE_CODE_INVALID", "PHONE_CODE_EMPTY"):
+		result.Code = AuthErrCodeInvalid
+	case tgerr.Is(err, "PHONE_CODE_EXPIRED"):
+		result.Code = AuthErrCodeExpired
+	case errors.Is(err, auth.ErrPasswordInvalid), tgerr.Is(err, "PASSWORD_HASH_INVALID"):
+		result.Code = AuthErrPasswordInvalid
+	case tgerr.Is(err, "PHONE_NUMBER_INVALID", "PHONE_NUMBER_BANNED"):
+		result.Code = AuthErrPhoneInvalid
+	case tgerr.Is(err, "FIRSTNAME_INVALID", "LASTNAME_INVALID"):
+		result.Code = AuthErrNameInvalid
 	}
+	return result
+}
 
-	log.Printf("Waiting for password from API for: %s", a.PhoneNumber)
-	password := <-ch
-	log.Printf("Received password from API for: %s", a.PhoneNumber)
+// SentCode описує, як Telegram доставив код входу
+type SentCode struct {
+	Type     string `json:"type"`
+	Length   int    `json:"length,omitempty"`
+	Timeout  int    `json:"timeout,omitempty"`
+	NextType string `json:"next_type,omitempty"`
 
-	return password, nil
+	hash string
 }
 
-// Code повертає код авторизації
-func (a AuthHandler) Code(_ context.Context, _ *tg.AuthSentCode) (string, error) {
-	// Чекаємо на код з API endpoint
-	ch, exists := authCodeChannel[a.PhoneNumber]
-	if !exists {
-		ch = make(chan string, 1)
-		authCodeChannel[a.PhoneNumber] = ch
+// newSentCode перетворює відповідь auth.sendCode / auth.resendCode
+func newSentCode(sent *tg.AuthSentCode) *SentCode {
+	code := &SentCode{
+		Type: sentCodeType(sent.Type),
+		hash: sent.PhoneCodeHash,
+	}
+	if withLength, ok := sent.Type.(interface{ GetLength() int }); ok {
+		code.Length = withLength.GetLength()
 	}
+	if timeout, ok := sent.GetTimeout(); ok {
+		code.Timeout = timeout
+	}
+	if next, ok := sent.GetNextType(); ok {
+		code.NextType = nextCodeType(next)
+	}
+	return code
+}
 
-	code := <-ch
-	return code, nil
+func sentCodeType(t tg.AuthSentCodeTypeClass) string {
+	switch t.(type) {
+	case *tg.AuthSentCodeTypeApp:
+		return "app"
+	case *tg.AuthSentCodeTypeSMS, *tg.AuthSentCodeTypeSMSWord, *tg.AuthSentCodeTypeSMSPhrase:
+		return "sms"
+	case *tg.AuthSentCodeTypeCall:
+		return "call"
+	case *tg.AuthSentCodeTypeFlashCall:
+		return "flash_call"
+	case *tg.AuthSentCodeTypeMissedCall:
+		return "missed_call"
+	case *tg.AuthSentCodeTypeEmailCode:
+		return "email"
+	case *tg.AuthSentCodeTypeSetUpEmailRequired:
+		return "email_setup_required"
+	case *tg.AuthSentCodeTypeFragmentSMS:
+		return "fragment_sms"
+	case *tg.AuthSentCodeTypeFirebaseSMS:
+		return "firebase_sms"
+	default:
+		return "unknown"
+	}
 }
 
-// AcceptTermsOfService приймає умови використання
-func (a AuthHandler) AcceptTermsOfService(_ context.Context, tos tg.HelpTermsOfService) error {
+func nextCodeType(t tg.AuthCodeTypeClass) string {
+	switch t.(type) {
+	case *tg.AuthCodeTypeSMS:
+		return "sms"
+	case *tg.AuthCodeTypeCall:
+		return "call"
+	case *tg.AuthCodeTypeFlashCall:
+		return "flash_call"
+	case *tg.AuthCodeTypeMissedCall:
+		return "missed_call"
+	case *tg.AuthCodeTypeFragmentSMS:
+		return "fragment_sms"
+	default:
+		return "unknown"
+	}
+}
+
+// AuthHandler проводить вхід для спроби attempt.
+// Колбеки Code і Password переводять спробу у відповідний стан
+// і чекають, поки користувач передасть код або пароль через API.
+type AuthHandler struct {
+	PhoneNumber string
+	attempt     *LoginAttempt
+}
+
+// Run надсилає код і перевіряє введені код та пароль. Невірний код
+// чи пароль повертають спробу в попередній стан з помилкою, тож
+// користувач може повторити введення без нового запиту коду.
+func (a *AuthHandler) Run(ctx context.Context, client *auth.Client) error {
+	sent, err := client.SendCode(ctx, a.PhoneNumber, auth.SendCodeOptions{})
+	if err != nil {
+		return AsAuthError(err)
+	}
+
+	var sentCode *tg.AuthSentCode
+	switch s := sent.(type) {
+	case *tg.AuthSentCode:
+		sentCode = s
+		a.attempt.setSentCode(newSentCode(s))
+	case *tg.AuthSentCodeSuccess:
+		// Сесія вже авторизована
+		if _, ok := s.Authorization.(*tg.AuthAuthorization); ok {
+			return nil
+		}
+		return fmt.Errorf("unexpected authorization type: %T", s.Authorization)
+	default:
+		return fmt.Errorf("unexpected sent code type: %T", sent)
+	}
+
+	var codeErr *AuthError
+	for {
+		code, err := a.Code(ctx, sentCode, codeErr)
+		if err != nil {
+			return err
+		}
+
+		// Після auth.resendCode хеш змінюється, тому беремо актуальний
+		hash := a.attempt.SentCode().hash
+		_, err = client.SignIn(ctx, a.PhoneNumber, code, hash)
+		if errors.Is(err, auth.ErrPasswordAuthNeeded) {
+			return a.checkPassword(ctx, client)
+		}
+
+		var signUpRequired *auth.SignUpRequired
+		if errors.As(err, &signUpRequired) {
+			return a.signUp(ctx, client, hash, signUpRequired)
+		}
+		if err == nil {
+			return nil
+		}
+
+		codeErr = AsAuthError(err)
+		if !codeErr.Retryable() {
+			return codeErr
+		}
+		log.Printf("Login: Code rejected for %s: %s", a.PhoneNumber, codeErr.Code)
+	}
+}
+
+// RunQR показує QR-токени (оновлюючи їх після закінчення терміну дії),
+// доки один з них не буде прийнято в іншому застосунку Telegram.
+// Якщо акаунт захищено 2FA, далі запитується пароль.
+func (a *AuthHandler) RunQR(ctx context.Context, client *telegram.Client, loggedIn qrlogin.LoggedIn) error {
+	_, err := client.QR().Auth(ctx, loggedIn, func(ctx context.Context, token qrlogin.Token) error {
+		a.attempt.setQRToken(token)
+		return nil
+	})
+	if tgerr.Is(err, "SESSION_PASSWORD_NEEDED") {
+		return a.checkPassword(ctx, client.Auth())
+	}
+	if err != nil {
+		return AsAuthError(err)
+	}
 	return nil
 }
 
-// SignUp виконує реєстрацію нового користувача
-func (a AuthHandler) SignUp(_ context.Context) (auth.UserInfo, error) {
-	return auth.UserInfo{
-		FirstName: "Symbian",
-		LastName:  "User",
-	}, nil
+// checkPassword запитує пароль 2FA, доки він не підійде
+func (a *AuthHandler) checkPassword(ctx context.Context, client *auth.Client) error {
+	var passwordErr *AuthError
+	for {
+		password, err := a.Password(ctx, passwordErr)
+		if err != nil {
+			return err
+		}
+
+		if _, err := client.Password(ctx, password); err != nil {
+			passwordErr = AsAuthError(err)
+			if !passwordErr.Retryable() {
+				return passwordErr
+			}
+			log.Printf("Login: Password rejected for %s: %s", a.PhoneNumber, passwordErr.Code)
+			continue
+		}
+		return nil
+	}
 }
 
-// StorePendingAuth зберігає інформацію про очікувану авторизацію
-func StorePendingAuth(phone, phoneHash string) {
-	pendingAuth[phone] = &PendingAuth{
-		Phone:         phone,
-		PhoneHash:     phoneHash,
-		NeedsPassword: false,
+// signUp реєструє новий номер після того, як користувач
+// вказав ім'я і явно прийняв умови використання
+func (a *AuthHandler) signUp(ctx context.Context, client *auth.Client, hash string, required *auth.SignUpRequired) error {
+	a.attempt.setTerms(newTermsOfService(required.TermsOfService))
+
+	var signUpErr *AuthError
+	for {
+		info, err := a.SignUp(ctx, signUpErr)
+		if err != nil {
+			return err
+		}
+
+		if required.TermsOfService.ID.Data != "" {
+			if err := client.AcceptTOS(ctx, required.TermsOfService.ID); err != nil {
+				return fmt.Errorf("accept terms of service error: %w", err)
+			}
+		}
+
+		_, err = client.SignUp(ctx, auth.SignUp{
+			PhoneNumber:   a.PhoneNumber,
+			PhoneCodeHash: hash,
+			FirstName:     info.FirstName,
+			LastName:      info.LastName,
+		})
+		if err == nil {
+			return nil
+		}
+
+		signUpErr = AsAuthError(err)
+		if !signUpErr.Retryable() {
+			return signUpErr
+		}
+		log.Printf("Login: Sign up rejected for %s: %s", a.PhoneNumber, signUpErr.Code)
 	}
 }
 
-// SubmitCode відправляє код авторизації
-func SubmitCode(phone, code string) error {
-	ch, exists := authCodeChannel[phone]
-	if !exists {
-		return fmt.Errorf("no pending auth for phone %s", phone)
+// Password повертає пароль (якщо потрібен)
+func (a *AuthHandler) Password(ctx context.Context, lastErr *AuthError) (string, error) {
+	log.Printf("2FA Password requested for: %s", a.PhoneNumber)
+	a.attempt.setState(LoginPasswordRequired, authErr(lastErr))
+
+	select {
+	case password := <-a.attempt.passwordCh:
+		return password, nil
+	case <-ctx.Done():
+		return "", ctx.Err()
 	}
+}
 
-	ch <- code
-	return nil
+// Code повертає код авторизації
+func (a *AuthHandler) Code(ctx context.Context, _ *tg.AuthSentCode, lastErr *AuthError) (string, error) {
+	a.attempt.setState(LoginCodeSent, authErr(lastErr))
+
+	select {
+	case code := <-a.attempt.codeCh:
+		return code, nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
 }
 
-// SubmitPassword відправляє пароль 2FA
-func SubmitPassword(phone, password string) error {
-	ch, exists := authPasswordChannel[phone]
-	if !exists {
-		return fmt.Errorf("no pending password request for phone %s", phone)
+// SignUp чекає ім'я нового користувача (умови вже прийнято в API)
+func (a *AuthHandler) SignUp(ctx context.Context, lastErr *AuthError) (auth.UserInfo, error) {
+	log.Printf("Sign up requested for: %s", a.PhoneNumber)
+	a.attempt.setState(LoginSignUpRequired, authErr(lastErr))
+
+	select {
+	case info := <-a.attempt.signUpCh:
+		return info, nil
+	case <-ctx.Done():
+		return auth.UserInfo{}, ctx.Err()
 	}
+}
 
-	ch <- password
-	return nil
+// TermsOfService - умови використання Telegram, які треба прийняти при реєстрації
+type TermsOfService struct {
+	Text          string `json:"text"`
+	MinAgeConfirm int    `json:"min_age_confirm,omitempty"`
+	Popup         bool   `json:"popup"`
 }
 
-// GetPendingAuth отримує інформацію про очікувану авторизацію
-func GetPendingAuth(phone string) (*PendingAuth, bool) {
-	auth, exists := pendingAuth[phone]
-	return auth, exists
+func newTermsOfService(tos tg.HelpTermsOfService) *TermsOfService {
+	terms := &TermsOfService{
+		Text:  tos.Text,
+		Popup: tos.Popup,
+	}
+	if age, ok := tos.GetMinAgeConfirm(); ok {
+		terms.MinAgeConfirm = age
+	}
+	return terms
 }
 
-// ClearPendingAuth очищає інформацію про очікувану авторизацію
-func ClearPendingAuth(phone string) {
-	delete(pendingAuth, phone)
-	delete(authCodeChannel, phone)
-	delete(authPasswordChannel, phone)
+// authErr не дає типізованому nil стати ненульовим error
+func authErr(err *AuthError) error {
+	if err == nil {
+		return nil
+	}
+	return err
 }
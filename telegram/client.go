@@ -3,81 +3,282 @@ package telegram
 import (
 	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"log"
-	"os"
+	"sync"
 	"telegram-gateway/config"
+	"telegram-gateway/storage"
 	"time"
 
 	"github.com/gotd/td/telegram"
-	"github.com/gotd/td/telegram/auth"
+	"github.com/gotd/td/telegram/updates"
+	"github.com/gotd/td/tg"
 )
 
+// ErrNotAuthorized повертається, якщо сесія не авторизована в Telegram
+var ErrNotAuthorized = errors.New("session is not authorized")
+
+// ErrConnectionClosed повертається, якщо постійне з'єднання вже закрите
+var ErrConnectionClosed = errors.New("connection closed")
+
+// ErrInvalidSession повертається для сесії, яку клієнт передав у неправильному форматі
+var ErrInvalidSession = errors.New("invalid session data")
+
+// ErrSessionStore позначає збій сховища сесій (на відміну від відсутньої сесії)
+var ErrSessionStore = errors.New("session store error")
+
 type Client struct {
-	Client      *telegram.Client
-	Config      *config.Config
-	SessionPath string
+	Client     *telegram.Client
+	Config     *config.Config
+	Store      storage.SessionStore
+	SessionKey string
+
+	// Access hash співрозмовників акаунта
+	peers *PeerStore
+
+	// Менеджер оновлень, його стан і підписники на оновлення (див. updates.go)
+	gaps        *updates.Manager
+	updateState *UpdateStateStorage
+	subMu       sync.Mutex
+	subs        map[int]*Subscription
+	nextSubID   int
+	recent      []Message
+
+	// Журнал подій акаунта для /api/updates (задає Manager)
+	events *EventLog
+
+	// Кеш кількості непрочитаних (скидається будь-якою подією акаунта)
+	unreadMu    sync.Mutex
+	unread      int
+	unreadValid bool
+	unreadGen   int
+
+	// Збережені сторінки діалогів і версії чатів (див. dialog_cache.go)
+	dialogsMu     sync.Mutex
+	dialogPages   map[string]DialogsPage
+	dialogStates  map[int64]dialogState
+	dialogVersion int64
+
+	// Надіслані повідомлення для відсіювання повторів (див. sent.go)
+	sent *sentLog
+
+	// Сигнал про прийняття QR-токена (UpdateLoginToken)
+	loginToken chan struct{}
+
+	// Стан постійного з'єднання (див. Start)
+	mu       sync.Mutex
+	ready    chan struct{}
+	done     chan struct{}
+	cancel   context.CancelFunc
+	connCtx  context.Context
+	runErr   error
+	lastUsed time.Time
+	self     *tg.User
 }
 
-// NewClient створює новий Telegram клієнт
-func NewClient(cfg *config.Config) (*Client, error) {
-	// Унікальний файл сесії для кожного клієнта
-	sessionPath := fmt.Sprintf("session_%d.json", time.Now().UnixNano())
+// NewClient створює новий Telegram клієнт, сесія якого зберігається
+// в сховищі store під ключем sessionKey
+func NewClient(cfg *config.Config, store storage.SessionStore, sessionKey string) (*Client, error) {
+	c := &Client{
+		Config:       cfg,
+		Store:        store,
+		SessionKey:   sessionKey,
+		peers:        NewPeerStore(),
+		subs:         make(map[int]*Subscription),
+		dialogPages:  make(map[string]DialogsPage),
+		dialogStates: make(map[int64]dialogState),
+		sent:         newSentLog(store, SentKey(sessionKey)),
+		loginToken:   make(chan struct{}, 1),
+	}
 
-	client := telegram.NewClient(cfg.TelegramAPIID, cfg.TelegramAPIHash, telegram.Options{
-		SessionStorage: &telegram.FileSessionStorage{
-			Path: sessionPath,
-		},
+	// Стан потоку оновлень зберігається поруч із сесією
+	state := NewUpdateStateStorage(store, UpdateStateKey(sessionKey), c.peers)
+	c.updateState = state
+	c.gaps = updates.New(updates.Config{
+		Handler:      &UpdatesHandler{client: c},
+		Storage:      state,
+		AccessHasher: state,
 	})
 
-	return &Client{
-		Client:      client,
-		Config:      cfg,
-		SessionPath: sessionPath,
-	}, nil
-}
+	c.Client = telegram.NewClient(cfg.TelegramAPIID, cfg.TelegramAPIHash, telegram.Options{
+		SessionStorage: &sessionStorage{store: store, key: sessionKey},
+		UpdateHandler:  c.gaps,
+	})
 
-// NewClientWithSession створює клієнт з існуючою сесією
-func NewClientWithSession(cfg *config.Config, sessionData string) (*Client, error) {
-	// Створюємо унікальний файл для цієї сесії
-	sessionPath := fmt.Sprintf("session_%d.json", time.Now().UnixNano())
+	return c, nil
+}
 
-	// Декодуємо base64 та зберігаємо в файл
+// ImportSession зберігає в сховищі сесію, передану клієнтом у base64
+func ImportSession(ctx context.Context, store storage.SessionStore, sessionKey, sessionData string) error {
 	decoded, err := base64.StdEncoding.DecodeString(sessionData)
 	if err != nil {
-		return nil, fmt.Errorf("failed to decode session data: %w", err)
+		return fmt.Errorf("%w: %w", ErrInvalidSession, err)
 	}
 
-	if err := os.WriteFile(sessionPath, decoded, 0600); err != nil {
-		return nil, fmt.Errorf("failed to write session file: %w", err)
+	if err := store.Save(ctx, sessionKey, decoded); err != nil {
+		return fmt.Errorf("%w: failed to store session: %w", ErrSessionStore, err)
 	}
-
-	client := telegram.NewClient(cfg.TelegramAPIID, cfg.TelegramAPIHash, telegram.Options{
-		SessionStorage: &telegram.FileSessionStorage{
-			Path: sessionPath,
-		},
-	})
-
-	return &Client{
-		Client:      client,
-		Config:      cfg,
-		SessionPath: sessionPath,
-	}, nil
+	return nil
 }
 
 // GetSessionData повертає дані сесії в base64
 func (c *Client) GetSessionData() (string, error) {
-	data, err := os.ReadFile(c.SessionPath)
+	data, err := c.Store.Load(context.Background(), c.SessionKey)
 	if err != nil {
-		return "", fmt.Errorf("failed to read session file: %w", err)
+		return "", fmt.Errorf("failed to load session: %w", err)
 	}
 
 	return base64.StdEncoding.EncodeToString(data), nil
 }
 
+// Start піднімає постійне з'єднання з Telegram у фоні.
+// Повертається, коли з'єднання готове до виконання запитів.
+func (c *Client) Start(ctx context.Context) error {
+	c.mu.Lock()
+	if c.ready != nil {
+		c.mu.Unlock()
+		return fmt.Errorf("client already started")
+	}
+	runCtx, cancel := context.WithCancel(context.Background())
+	c.ready = make(chan struct{})
+	c.done = make(chan struct{})
+	c.cancel = cancel
+	c.lastUsed = time.Now()
+	ready, done := c.ready, c.done
+	c.mu.Unlock()
+
+	go func() {
+		err := c.Client.Run(runCtx, func(ctx context.Context) error {
+			c.mu.Lock()
+			c.connCtx = ctx
+			c.mu.Unlock()
+
+			close(ready)
+			<-ctx.Done()
+			return nil
+		})
+
+		c.mu.Lock()
+		c.runErr = err
+		c.mu.Unlock()
+		c.closeSubscribers()
+		close(done)
+	}()
+
+	select {
+	case <-ready:
+		return nil
+	case <-done:
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.runErr != nil {
+			return fmt.Errorf("connection error: %w", c.runErr)
+		}
+		return ErrConnectionClosed
+	case <-ctx.Done():
+		cancel()
+		return ctx.Err()
+	}
+}
+
+// Stop закриває постійне з'єднання та чекає його завершення
+func (c *Client) Stop() {
+	c.mu.Lock()
+	cancel, done := c.cancel, c.done
+	c.mu.Unlock()
+
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+
+	if err := c.updateState.Close(context.Background()); err != nil {
+		log.Printf("Updates: Failed to save state %s: %v", c.SessionKey, err)
+	}
+}
+
+// Alive повідомляє, чи постійне з'єднання активне
+func (c *Client) Alive() bool {
+	c.mu.Lock()
+	ready, done := c.ready, c.done
+	c.mu.Unlock()
+
+	if ready == nil {
+		return false
+	}
+	select {
+	case <-done:
+		return false
+	case <-ready:
+		return true
+	default:
+		return false
+	}
+}
+
+// Touch оновлює час останнього використання клієнта
+func (c *Client) Touch() {
+	c.mu.Lock()
+	c.lastUsed = time.Now()
+	c.mu.Unlock()
+}
+
+// LastUsed повертає час останнього використання клієнта
+func (c *Client) LastUsed() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lastUsed
+}
+
+// Self повертає авторизованого користувача (nil, якщо ще невідомий)
+func (c *Client) Self() *tg.User {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.self
+}
+
+// selfID повертає ID авторизованого користувача (0, якщо ще невідомий)
+func (c *Client) selfID() int64 {
+	if self := c.Self(); self != nil {
+		return self.ID
+	}
+	return 0
+}
+
+func (c *Client) setSelf(user *tg.User) {
+	c.mu.Lock()
+	c.self = user
+	c.mu.Unlock()
+}
+
+// run виконує fn всередині постійного з'єднання.
+// Якщо з'єднання не запущене - відкриває разове через Run().
+func (c *Client) run(ctx context.Context, fn func(ctx context.Context) error) error {
+	c.mu.Lock()
+	ready, done := c.ready, c.done
+	c.mu.Unlock()
+
+	if ready == nil {
+		return c.Client.Run(ctx, fn)
+	}
+
+	select {
+	case <-ready:
+	case <-done:
+		return ErrConnectionClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	c.Touch()
+	return fn(ctx)
+}
+
 // Connect підключається до Telegram
 func (c *Client) Connect(ctx context.Context) error {
-	return c.Client.Run(ctx, func(ctx context.Context) error {
+	return c.run(ctx, func(ctx context.Context) error {
 		status, err := c.Client.Auth().Status(ctx)
 		if err != nil {
 			return fmt.Errorf("auth status error: %w", err)
@@ -99,17 +300,32 @@ func (c *Client) Connect(ctx context.Context) error {
 }
 
 // Auth виконує авторизацію через номер телефону
-func (c *Client) Auth(ctx context.Context, phone string) error {
-	return c.Client.Run(ctx, func(ctx context.Context) error {
-		flow := auth.NewFlow(
-			&AuthHandler{PhoneNumber: phone},
-			auth.SendCodeOptions{},
-		)
+func (c *Client) Auth(ctx context.Context, handler *AuthHandler) error {
+	return c.runAuth(ctx, func(ctx context.Context) error {
+		return handler.Run(ctx, c.Client.Auth())
+	})
+}
+
+// QRAuth виконує авторизацію за QR-кодом, підтвердженим в іншому застосунку
+func (c *Client) QRAuth(ctx context.Context, handler *AuthHandler) error {
+	return c.runAuth(ctx, func(ctx context.Context) error {
+		return handler.RunQR(ctx, c.Client, c.loginToken)
+	})
+}
 
-		if err := c.Client.Auth().IfNecessary(ctx, flow); err != nil {
+// runAuth проводить вхід у межах одноразового з'єднання і запам'ятовує акаунт
+func (c *Client) runAuth(ctx context.Context, login func(ctx context.Context) error) error {
+	return c.Client.Run(ctx, func(ctx context.Context) error {
+		if err := login(ctx); err != nil {
 			return fmt.Errorf("auth error: %w", err)
 		}
 
+		self, err := c.Client.Self(ctx)
+		if err != nil {
+			return fmt.Errorf("get self error: %w", err)
+		}
+		c.setSelf(self)
+
 		return nil
 	})
 }
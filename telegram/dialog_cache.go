@@ -0,0 +1,152 @@
+package telegram
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gotd/td/tg"
+)
+
+// Скільки сторінок діалогів кешується для параметра hash
+const dialogPagesLimit = 16
+
+// dialogState - останній відомий стан чату для відбору змін за версією
+type dialogState struct {
+	fingerprint string
+	version     int64
+}
+
+// telegramHash рахує hash для кешування за алгоритмом Telegram
+// (https://core.telegram.org/api/offsets#hash-generation)
+func telegramHash(values []int64) int64 {
+	var hash uint64
+	for _, v := range values {
+		hash ^= hash >> 21
+		hash ^= hash << 35
+		hash ^= hash >> 4
+		hash += uint64(v)
+	}
+	return int64(hash)
+}
+
+// dialogsHash рахує hash сторінки з ID чатів, останніх повідомлень і їх дат
+func dialogsHash(slice *tg.MessagesDialogsSlice) int64 {
+	dates := make(map[int64]int)
+	for _, m := range slice.Messages {
+		if dated, ok := m.(interface{ GetDate() int }); ok {
+			dates[MarkedPeerID(messagePeer(m))] = dated.GetDate()
+		}
+	}
+
+	var values []int64
+	for _, d := range slice.Dialogs {
+		dialog, ok := d.(*tg.Dialog)
+		if !ok {
+			continue
+		}
+		peerID := MarkedPeerID(dialog.Peer)
+		values = append(values, peerID, int64(dialog.TopMessage), int64(dates[peerID]))
+	}
+	return telegramHash(values)
+}
+
+func dialogsCacheKey(folderID, limit int, cursor *DialogCursor) string {
+	key := fmt.Sprintf("%d:%d", folderID, limit)
+	if cursor != nil {
+		key += ":" + cursor.Encode()
+	}
+	return key
+}
+
+// cachedDialogs повертає копію сторінки, збереженої для такого самого запиту
+func (c *Client) cachedDialogs(key string) (DialogsPage, bool) {
+	c.dialogsMu.Lock()
+	defer c.dialogsMu.Unlock()
+
+	page, ok := c.dialogPages[key]
+	if ok {
+		page.Dialogs = append([]Dialog(nil), page.Dialogs...)
+	}
+	return page, ok
+}
+
+func (c *Client) storeDialogs(key string, page *DialogsPage) {
+	c.dialogsMu.Lock()
+	defer c.dialogsMu.Unlock()
+
+	if _, ok := c.dialogPages[key]; !ok && len(c.dialogPages) >= dialogPagesLimit {
+		c.dialogPages = make(map[string]DialogsPage)
+	}
+	stored := *page
+	stored.Dialogs = append([]Dialog(nil), page.Dialogs...)
+	c.dialogPages[key] = stored
+}
+
+// invalidateDialogs забуває збережені сторінки: після події акаунта
+// hash старої відповіді вже не можна передавати в Telegram
+func (c *Client) invalidateDialogs() {
+	c.dialogsMu.Lock()
+	c.dialogPages = make(map[string]DialogsPage)
+	c.dialogsMu.Unlock()
+}
+
+// trackDialogs присвоює чатам сторінки версії: чат отримує нову версію,
+// щойно змінився його вміст. Версії - мілісекунди часу зміни, тож після
+// перезапуску шлюзу вони лише зростають і клієнт отримає повний список.
+func (c *Client) trackDialogs(page *DialogsPage) {
+	c.dialogsMu.Lock()
+	defer c.dialogsMu.Unlock()
+
+	next := time.Now().UnixMilli()
+	if next <= c.dialogVersion {
+		next = c.dialogVersion + 1
+	}
+
+	changed := false
+	for i := range page.Dialogs {
+		d := &page.Dialogs[i]
+		fingerprint := d.fingerprint()
+		state, ok := c.dialogStates[d.ID]
+		if !ok || state.fingerprint != fingerprint {
+			state = dialogState{fingerprint: fingerprint, version: next}
+			c.dialogStates[d.ID] = state
+			changed = true
+		}
+		d.version = state.version
+	}
+	if changed {
+		c.dialogVersion = next
+	}
+	page.Version = c.dialogVersion
+}
+
+// fingerprint - усе, що клієнт бачить у чаті, крім часу без останнього повідомлення
+func (d Dialog) fingerprint() string {
+	return fmt.Sprintf("%s|%d|%s|%d|%t|%d|%d|%t|%d|%t",
+		d.Name, d.topMessage, d.LastMessage, d.UnreadCount, d.Pinned, d.PinnedOrder,
+		d.FolderID, d.Muted, d.UnreadMentions, d.HasDraft)
+}
+
+// ChangedSince повертає чати сторінки, що змінилися після версії since
+func (p *DialogsPage) ChangedSince(since int64) []Dialog {
+	var changed []Dialog
+	for _, d := range p.Dialogs {
+		if d.version > since {
+			changed = append(changed, d)
+		}
+	}
+	return changed
+}
+
+// ETag - стабільний ідентифікатор вмісту сторінки: порядок чатів і їх версії
+func (p *DialogsPage) ETag() string {
+	values := make([]int64, 0, 2*len(p.Dialogs)+5)
+	values = append(values, int64(p.Total))
+	for _, d := range p.Dialogs {
+		values = append(values, d.ID, d.version)
+	}
+	if next := p.NextCursor; next != nil {
+		values = append(values, int64(next.OffsetDate), int64(next.OffsetID), next.OffsetPeer, int64(next.Seen))
+	}
+	return fmt.Sprintf("%016x", uint64(telegramHash(values)))
+}
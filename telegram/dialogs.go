@@ -2,6 +2,7 @@ package telegram
 
 import (
 	"context"
+	"encoding/base64"
 	"fmt"
 	"time"
 
@@ -9,27 +10,127 @@ import (
 )
 
 type Dialog struct {
-	ID             int64     `json:"id"`
+	ID             int64     `json:"id"` // позначений ID (див. MarkedPeerID)
 	Name           string    `json:"name"`
 	LastMessage    string    `json:"last_message"`
 	UnreadCount    int       `json:"unread_count"`
 	LastUpdateTime time.Time `json:"last_update_time"`
 	Type           string    `json:"type"` // "user", "chat", "channel"
+	Pinned         bool      `json:"pinned"`
+	// Позиція серед закріплених, починаючи з 1 (0 - не закріплений)
+	PinnedOrder    int  `json:"pinned_order,omitempty"`
+	FolderID       int  `json:"folder_id"` // 0 - основний список, 1 - архів
+	Muted          bool `json:"muted"`
+	UnreadMentions int  `json:"unread_mentions"`
+	HasDraft       bool `json:"has_draft"`
+
+	// Для відбору в теках (див. folders.go)
+	contact    bool
+	bot        bool
+	broadcast  bool
+	unreadMark bool
+	topMessage int
+	// Версія останньої зміни чату (див. dialog_cache.go)
+	version int64
+}
+
+// AllFolders - GetDialogsPage без фільтра за текою (основний список разом з архівом)
+const AllFolders = -1
+
+// ArchiveFolderID - тека архіву в Telegram
+const ArchiveFolderID = 1
+
+// DialogCursor - позиція в списку діалогів (параметри offset_* messages.getDialogs)
+type DialogCursor struct {
+	OffsetDate int
+	OffsetID   int
+	OffsetPeer int64 // позначений ID
+	// Скільки діалогів уже отримано - для порівняння з Count
+	Seen int
+}
+
+// Encode кодує курсор у непрозорий рядок для клієнта
+func (dc DialogCursor) Encode() string {
+	raw := fmt.Sprintf("%d:%d:%d:%d", dc.OffsetDate, dc.OffsetID, dc.OffsetPeer, dc.Seen)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// ParseDialogCursor розбирає курсор, отриманий від клієнта
+func ParseDialogCursor(cursor string) (*DialogCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor: %w", err)
+	}
+
+	var dc DialogCursor
+	if _, err := fmt.Sscanf(string(raw), "%d:%d:%d:%d", &dc.OffsetDate, &dc.OffsetID, &dc.OffsetPeer, &dc.Seen); err != nil {
+		return nil, fmt.Errorf("invalid cursor: %w", err)
+	}
+	return &dc, nil
 }
 
-// GetDialogs отримує список діалогів (чатів)
+// DialogsPage - сторінка списку діалогів
+type DialogsPage struct {
+	Dialogs    []Dialog
+	Total      int
+	NextCursor *DialogCursor // nil, якщо це остання сторінка
+	// Версія списку чатів акаунта на момент запиту (див. ChangedSince)
+	Version int64
+
+	// hash відповіді Telegram для наступного такого самого запиту
+	hash int64
+}
+
+// GetDialogs отримує перші limit діалогів (чатів)
 func (c *Client) GetDialogs(ctx context.Context, limit int) ([]Dialog, error) {
+	page, err := c.GetDialogsPage(ctx, AllFolders, limit, nil)
+	if err != nil {
+		return nil, err
+	}
+	return page.Dialogs, nil
+}
+
+// GetDialogsPage отримує сторінку діалогів теки folderID (0 - основний список,
+// 1 - архів, AllFolders - усі), що йде після cursor (nil - з початку списку)
+func (c *Client) GetDialogsPage(ctx context.Context, folderID, limit int, cursor *DialogCursor) (*DialogsPage, error) {
+	page := &DialogsPage{}
 	var dialogs []Dialog
 
-	err := c.Client.Run(ctx, func(ctx context.Context) error {
-		// Отримуємо API клієнт всередині Run callback
+	// Попередня відповідь на такий самий запит - для параметра hash
+	cacheKey := dialogsCacheKey(folderID, limit, cursor)
+	cached, hasCached := c.cachedDialogs(cacheKey)
+	notModified := false
+
+	err := c.run(ctx, func(ctx context.Context) error {
+		// Отримуємо API клієнт всередині з'єднання
 		api := c.Client.API()
 
-		// Отримуємо діалоги
-		result, err := api.MessagesGetDialogs(ctx, &tg.MessagesGetDialogsRequest{
+		request := &tg.MessagesGetDialogsRequest{
 			OffsetPeer: &tg.InputPeerEmpty{},
 			Limit:      limit,
-		})
+		}
+		if folderID != AllFolders {
+			request.SetFolderID(folderID)
+		}
+		seen := 0
+		if cursor != nil {
+			request.OffsetDate = cursor.OffsetDate
+			request.OffsetID = cursor.OffsetID
+			seen = cursor.Seen
+			if cursor.OffsetPeer != 0 {
+				peer, err := c.GetInputPeer(ctx, cursor.OffsetPeer)
+				if err != nil {
+					return fmt.Errorf("get offset peer error: %w", err)
+				}
+				request.OffsetPeer = peer
+			}
+		}
+		if hasCached {
+			request.Hash = cached.hash
+		}
+
+		// Отримуємо діалоги
+		result, err := api.MessagesGetDialogs(ctx, request)
 		if err != nil {
 			return fmt.Errorf("get dialogs error: %w", err)
 		}
@@ -37,8 +138,10 @@ func (c *Client) GetDialogs(ctx context.Context, limit int) ([]Dialog, error) {
 		var dialogsSlice *tg.MessagesDialogsSlice
 		switch d := result.(type) {
 		case *tg.MessagesDialogs:
-			// Конвертуємо MessagesDialogs в MessagesDialogsSlice
+			// Конвертуємо MessagesDialogs в MessagesDialogsSlice;
+			// це повний список, тож Count - його довжина
 			dialogsSlice = &tg.MessagesDialogsSlice{
+				Count:    seen + len(d.Dialogs),
 				Dialogs:  d.Dialogs,
 				Messages: d.Messages,
 				Chats:    d.Chats,
@@ -47,11 +150,24 @@ func (c *Client) GetDialogs(ctx context.Context, limit int) ([]Dialog, error) {
 		case *tg.MessagesDialogsSlice:
 			dialogsSlice = d
 		case *tg.MessagesDialogsNotModified:
+			if !hasCached {
+				return fmt.Errorf("dialogs not modified, but nothing is cached")
+			}
+			notModified = true
+			cached.Total = d.Count
 			return nil
 		default:
 			return fmt.Errorf("unexpected dialogs type: %T", result)
 		}
 
+		page.Total = dialogsSlice.Count
+		page.hash = dialogsHash(dialogsSlice)
+		if next, ok := nextDialogCursor(dialogsSlice, seen); ok {
+			page.NextCursor = next
+		}
+
+		c.peers.Apply(dialogsSlice.Users, dialogsSlice.Chats)
+
 		// Створюємо мапи для швидкого доступу
 		users := make(map[int64]*tg.User)
 		chats := make(map[int64]tg.ChatClass)
@@ -81,66 +197,136 @@ func (c *Client) GetDialogs(ctx context.Context, limit int) ([]Dialog, error) {
 		// Заповнюємо мапу повідомлень
 		for _, m := range dialogsSlice.Messages {
 			if msg, ok := m.(*tg.Message); ok {
-				peerID := GetPeerID(msg.PeerID)
+				peerID := MarkedPeerID(msg.PeerID)
 				messages[peerID] = msg
 			}
 		}
 
 		// Обробляємо діалоги
-		for _, d := range dialogsSlice.Dialogs {
+		now := time.Now().Unix()
+		for i, d := range dialogsSlice.Dialogs {
 			dialog, ok := d.(*tg.Dialog)
 			if !ok {
 				continue
 			}
 
-			peerID := GetPeerID(dialog.Peer)
-			name := ""
-			dialogType := ""
+			peerID := MarkedPeerID(dialog.Peer)
+			result := Dialog{
+				ID:             peerID,
+				UnreadCount:    dialog.UnreadCount,
+				Pinned:         dialog.Pinned,
+				FolderID:       dialog.FolderID,
+				UnreadMentions: dialog.UnreadMentionsCount,
+				unreadMark:     dialog.UnreadMark,
+				topMessage:     dialog.TopMessage,
+			}
+			// Закріплені діалоги Telegram завжди віддає на початку списку
+			if dialog.Pinned {
+				result.PinnedOrder = seen + i + 1
+			}
+			if muteUntil, ok := dialog.NotifySettings.GetMuteUntil(); ok && int64(muteUntil) > now {
+				result.Muted = true
+			}
+			if _, ok := dialog.Draft.(*tg.DraftMessage); ok {
+				result.HasDraft = true
+			}
 
 			// Визначаємо тип і ім'я діалогу
 			switch peer := dialog.Peer.(type) {
 			case *tg.PeerUser:
 				if user, exists := users[peer.UserID]; exists {
-					name = GetUserName(user)
-					dialogType = "user"
+					result.Name = GetUserName(user)
+					result.Type = "user"
+					result.contact = user.Contact
+					result.bot = user.Bot
 				}
 			case *tg.PeerChat:
 				if chat, exists := chats[peer.ChatID]; exists {
-					name = GetChatTitle(chat)
-					dialogType = "chat"
+					result.Name = GetChatTitle(chat)
+					result.Type = "chat"
 				}
 			case *tg.PeerChannel:
 				if channel, exists := chats[peer.ChannelID]; exists {
-					name = GetChatTitle(channel)
-					dialogType = "channel"
+					result.Name = GetChatTitle(channel)
+					result.Type = "channel"
+					if ch, ok := channel.(*tg.Channel); ok {
+						result.broadcast = ch.Broadcast
+					}
 				}
 			}
 
 			// Отримуємо останнє повідомлення
-			lastMessage := ""
-			lastUpdateTime := time.Now()
+			result.LastUpdateTime = time.Now()
 			if msg, exists := messages[peerID]; exists {
-				lastMessage = msg.Message
-				lastUpdateTime = time.Unix(int64(msg.Date), 0)
+				result.LastMessage = msg.Message
+				result.LastUpdateTime = time.Unix(int64(msg.Date), 0)
 			}
 
-			dialogs = append(dialogs, Dialog{
-				ID:             peerID,
-				Name:           name,
-				LastMessage:    lastMessage,
-				UnreadCount:    dialog.UnreadCount,
-				LastUpdateTime: lastUpdateTime,
-				Type:           dialogType,
-			})
+			dialogs = append(dialogs, result)
 		}
 
 		return nil
 	})
+	if err != nil {
+		return nil, err
+	}
+
+	if notModified {
+		page = &cached
+	} else {
+		page.Dialogs = dialogs
+		c.storeDialogs(cacheKey, page)
+	}
+	c.trackDialogs(page)
+	return page, nil
+}
+
+// nextDialogCursor будує курсор наступної сторінки від останнього діалогу
+func nextDialogCursor(slice *tg.MessagesDialogsSlice, seen int) (*DialogCursor, bool) {
+	seen += len(slice.Dialogs)
+	if len(slice.Dialogs) == 0 || seen >= slice.Count {
+		return nil, false
+	}
+
+	last := slice.Dialogs[len(slice.Dialogs)-1]
+	peerID := MarkedPeerID(last.GetPeer())
+	topMessage := 0
+	if dialog, ok := last.(*tg.Dialog); ok {
+		topMessage = dialog.TopMessage
+	}
+
+	next := &DialogCursor{
+		OffsetID:   topMessage,
+		OffsetPeer: peerID,
+		Seen:       seen,
+	}
+	for _, m := range slice.Messages {
+		if m.GetID() == topMessage && MarkedPeerID(messagePeer(m)) == peerID {
+			if dated, ok := m.(interface{ GetDate() int }); ok {
+				next.OffsetDate = dated.GetDate()
+			}
+			break
+		}
+	}
+	return next, true
+}
 
-	return dialogs, err
+// messagePeer повертає чат повідомлення будь-якого типу
+func messagePeer(m tg.MessageClass) tg.PeerClass {
+	switch msg := m.(type) {
+	case *tg.Message:
+		return msg.PeerID
+	case *tg.MessageService:
+		return msg.PeerID
+	case *tg.MessageEmpty:
+		if peer, ok := msg.GetPeerID(); ok {
+			return peer
+		}
+	}
+	return &tg.PeerUser{}
 }
 
-// GetPeerID отримує ID з Peer
+// GetPeerID отримує сирий ID з Peer (без позначки типу)
 func GetPeerID(peer tg.PeerClass) int64 {
 	switch p := peer.(type) {
 	case *tg.PeerUser:
@@ -179,3 +365,46 @@ func GetChatTitle(chat tg.ChatClass) string {
 	}
 	return "Unknown"
 }
+
+// Скільки перших чатів враховується в UnreadCount
+const unreadDialogsLimit = 100
+
+// UnreadCount повертає кількість непрочитаних повідомлень у перших
+// unreadDialogsLimit чатах. Значення кешується до наступної події акаунта.
+func (c *Client) UnreadCount(ctx context.Context) (int, error) {
+	c.unreadMu.Lock()
+	if c.unreadValid {
+		unread := c.unread
+		c.unreadMu.Unlock()
+		return unread, nil
+	}
+	gen := c.unreadGen
+	c.unreadMu.Unlock()
+
+	dialogs, err := c.GetDialogs(ctx, unreadDialogsLimit)
+	if err != nil {
+		return 0, err
+	}
+
+	unread := 0
+	for _, d := range dialogs {
+		unread += d.UnreadCount
+	}
+
+	// Подія під час запиту могла змінити лічильник - тоді не кешуємо
+	c.unreadMu.Lock()
+	if c.unreadGen == gen {
+		c.unread = unread
+		c.unreadValid = true
+	}
+	c.unreadMu.Unlock()
+
+	return unread, nil
+}
+
+func (c *Client) invalidateUnread() {
+	c.unreadMu.Lock()
+	c.unreadValid = false
+	c.unreadGen++
+	c.unreadMu.Unlock()
+}
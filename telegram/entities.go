@@ -0,0 +1,284 @@
+package telegram
+
+import (
+	"html"
+	"net/url"
+	"sort"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/gotd/td/tg"
+)
+
+// TextFormat - формат, у якому клієнт отримує текст повідомлень
+type TextFormat string
+
+const (
+	// FormatPlain - простий текст; адреси прихованих посилань додаються в дужках
+	FormatPlain TextFormat = "plain"
+	// FormatMarkup - мінімальна розмітка в квадратних дужках для J2ME
+	FormatMarkup TextFormat = "markup"
+	// FormatHTML - очищений HTML
+	FormatHTML TextFormat = "html"
+)
+
+// ParseTextFormat розбирає назву формату; порожня назва - FormatPlain
+func ParseTextFormat(name string) (TextFormat, bool) {
+	switch format := TextFormat(strings.ToLower(name)); format {
+	case "":
+		return FormatPlain, true
+	case FormatPlain, FormatMarkup, FormatHTML:
+		return format, true
+	}
+	return "", false
+}
+
+// Link - посилання з тексту повідомлення
+type Link struct {
+	URL  string `json:"url"`
+	Text string `json:"text"`
+}
+
+// Formatted повертає копію повідомлення з текстом у форматі format
+func (m Message) Formatted(format TextFormat) Message {
+	if m.raw == "" || format == FormatPlain && len(m.entities) == 0 {
+		return m
+	}
+	m.Text = RenderText(m.raw, m.entities, format)
+	return m
+}
+
+// textSpan - ділянка тексту під однією сутністю, в одиницях UTF-16
+type textSpan struct {
+	start, end int
+	entity     tg.MessageEntityClass
+}
+
+// RenderText перетворює текст із сутностями Telegram на формат format.
+// Зсуви сутностей рахуються в одиницях UTF-16, як у MTProto.
+// Сутності, що перетинаються, закриваються й відкриваються знову.
+func RenderText(text string, entities []tg.MessageEntityClass, format TextFormat) string {
+	units := utf16.Encode([]rune(text))
+
+	var spans []textSpan
+	boundaries := map[int]bool{0: true, len(units): true}
+	for _, e := range entities {
+		start, end := e.GetOffset(), e.GetOffset()+e.GetLength()
+		if start < 0 || end > len(units) || start >= end {
+			continue
+		}
+		spans = append(spans, textSpan{start: start, end: end, entity: e})
+		boundaries[start] = true
+		boundaries[end] = true
+	}
+	// Довші сутності відкриваються раніше, щоб коротші були вкладені в них
+	sort.SliceStable(spans, func(i, j int) bool {
+		if spans[i].start != spans[j].start {
+			return spans[i].start < spans[j].start
+		}
+		return spans[i].end > spans[j].end
+	})
+
+	positions := make([]int, 0, len(boundaries))
+	for pos := range boundaries {
+		positions = append(positions, pos)
+	}
+	sort.Ints(positions)
+
+	var b strings.Builder
+	var stack []textSpan
+	next := 0
+	for i, pos := range positions {
+		// Закриваємо сутності, що закінчуються тут; вищі за них у стеку
+		// закриваються тимчасово і відкриваються знову
+		cut := len(stack)
+		for k := range stack {
+			if stack[k].end <= pos {
+				cut = k
+				break
+			}
+		}
+		var reopen []textSpan
+		for k := len(stack) - 1; k >= cut; k-- {
+			writeClose(&b, stack[k], pos, units, format)
+			if stack[k].end > pos {
+				reopen = append([]textSpan{stack[k]}, reopen...)
+			}
+		}
+		stack = stack[:cut]
+		for _, s := range reopen {
+			writeOpen(&b, s, format)
+			stack = append(stack, s)
+		}
+
+		for next < len(spans) && spans[next].start == pos {
+			writeOpen(&b, spans[next], format)
+			stack = append(stack, spans[next])
+			next++
+		}
+
+		if i+1 < len(positions) {
+			chunk := string(utf16.Decode(units[pos:positions[i+1]]))
+			b.WriteString(escapeText(chunk, format))
+		}
+	}
+	return b.String()
+}
+
+// EntityLinks повертає посилання з тексту: адреси, приховані посилання та email
+func EntityLinks(text string, entities []tg.MessageEntityClass) []Link {
+	units := utf16.Encode([]rune(text))
+
+	var links []Link
+	for _, e := range entities {
+		start, end := e.GetOffset(), e.GetOffset()+e.GetLength()
+		if start < 0 || end > len(units) || start >= end {
+			continue
+		}
+		label := string(utf16.Decode(units[start:end]))
+
+		switch entity := e.(type) {
+		case *tg.MessageEntityURL:
+			target := label
+			if !strings.Contains(target, "://") {
+				target = "http://" + target
+			}
+			links = append(links, Link{URL: target, Text: label})
+		case *tg.MessageEntityTextURL:
+			links = append(links, Link{URL: entity.URL, Text: label})
+		case *tg.MessageEntityEmail:
+			links = append(links, Link{URL: "mailto:" + label, Text: label})
+		}
+	}
+	return links
+}
+
+func writeOpen(b *strings.Builder, s textSpan, format TextFormat) {
+	switch format {
+	case FormatMarkup:
+		if tag := markupTag(s.entity); tag != "" {
+			if link, ok := s.entity.(*tg.MessageEntityTextURL); ok {
+				b.WriteString("[url=" + markupURL(link.URL) + "]")
+				return
+			}
+			b.WriteString("[" + tag + "]")
+		}
+	case FormatHTML:
+		if link, ok := s.entity.(*tg.MessageEntityTextURL); ok {
+			if safeURL(link.URL) {
+				b.WriteString(`<a href="` + html.EscapeString(link.URL) + `">`)
+			}
+			return
+		}
+		if tag, attrs := htmlTag(s.entity); tag != "" {
+			b.WriteString("<" + tag + attrs + ">")
+		}
+	}
+}
+
+// writeClose закриває сутність на позиції pos (раніше за її кінець -
+// якщо її тимчасово закрито через перетин з іншою)
+func writeClose(b *strings.Builder, s textSpan, pos int, units []uint16, format TextFormat) {
+	switch format {
+	case FormatPlain:
+		// Адресу прихованого посилання показуємо після його тексту,
+		// лише коли дійшли до справжнього кінця посилання
+		if link, ok := s.entity.(*tg.MessageEntityTextURL); ok && s.end <= pos {
+			label := string(utf16.Decode(units[s.start:s.end]))
+			if label != link.URL {
+				b.WriteString(" (" + link.URL + ")")
+			}
+		}
+	case FormatMarkup:
+		if tag := markupTag(s.entity); tag != "" {
+			b.WriteString("[/" + tag + "]")
+		}
+	case FormatHTML:
+		if link, ok := s.entity.(*tg.MessageEntityTextURL); ok {
+			if safeURL(link.URL) {
+				b.WriteString("</a>")
+			}
+			return
+		}
+		if tag, _ := htmlTag(s.entity); tag != "" {
+			b.WriteString("</" + tag + ">")
+		}
+	}
+}
+
+// markupTag - тег розмітки J2ME для сутності ("" - без розмітки)
+func markupTag(e tg.MessageEntityClass) string {
+	switch e.(type) {
+	case *tg.MessageEntityBold:
+		return "b"
+	case *tg.MessageEntityItalic:
+		return "i"
+	case *tg.MessageEntityUnderline:
+		return "u"
+	case *tg.MessageEntityStrike:
+		return "s"
+	case *tg.MessageEntityCode:
+		return "code"
+	case *tg.MessageEntityPre:
+		return "pre"
+	case *tg.MessageEntitySpoiler:
+		return "spoiler"
+	case *tg.MessageEntityBlockquote:
+		return "quote"
+	case *tg.MessageEntityTextURL:
+		return "url"
+	}
+	return ""
+}
+
+// htmlTag - HTML тег і його атрибути для сутності ("" - без розмітки)
+func htmlTag(e tg.MessageEntityClass) (string, string) {
+	switch e.(type) {
+	case *tg.MessageEntityBold:
+		return "b", ""
+	case *tg.MessageEntityItalic:
+		return "i", ""
+	case *tg.MessageEntityUnderline:
+		return "u", ""
+	case *tg.MessageEntityStrike:
+		return "s", ""
+	case *tg.MessageEntityCode:
+		return "code", ""
+	case *tg.MessageEntityPre:
+		return "pre", ""
+	case *tg.MessageEntitySpoiler:
+		return "span", ` class="spoiler"`
+	case *tg.MessageEntityBlockquote:
+		return "blockquote", ""
+	}
+	return "", ""
+}
+
+// escapeText екранує звичайний текст для формату
+func escapeText(text string, format TextFormat) string {
+	switch format {
+	case FormatMarkup:
+		return strings.ReplaceAll(text, "[", "[[")
+	case FormatHTML:
+		return strings.ReplaceAll(html.EscapeString(text), "\n", "<br>")
+	}
+	return text
+}
+
+// markupURL готує адресу для [url=...]: закриваюча дужка кодується
+func markupURL(target string) string {
+	return strings.NewReplacer("]", "%5D", "[", "%5B").Replace(target)
+}
+
+// safeURL пропускає в HTML лише адреси з безпечною схемою
+func safeURL(target string) bool {
+	u, err := url.Parse(target)
+	if err != nil {
+		return false
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https", "mailto", "tg":
+		return true
+	}
+	return false
+}
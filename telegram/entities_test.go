@@ -0,0 +1,154 @@
+package telegram
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/gotd/td/tg"
+)
+
+func TestRenderText(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		entities []tg.MessageEntityClass
+		format   TextFormat
+		want     string
+	}{
+		{
+			name:   "plain without entities",
+			text:   "hello [world] <b>",
+			format: FormatPlain,
+			want:   "hello [world] <b>",
+		},
+		{
+			name:   "markup escapes brackets",
+			text:   "a [b]",
+			format: FormatMarkup,
+			want:   "a [[b]",
+		},
+		{
+			name:   "html escapes and keeps line breaks",
+			text:   "a < b & c\nd",
+			format: FormatHTML,
+			want:   "a &lt; b &amp; c<br>d",
+		},
+		{
+			name:     "bold in markup",
+			text:     "hello world",
+			entities: []tg.MessageEntityClass{&tg.MessageEntityBold{Offset: 6, Length: 5}},
+			format:   FormatMarkup,
+			want:     "hello [b]world[/b]",
+		},
+		{
+			// 😀 займає дві одиниці UTF-16
+			name:     "offsets after emoji",
+			text:     "😀 hi there",
+			entities: []tg.MessageEntityClass{&tg.MessageEntityItalic{Offset: 3, Length: 2}},
+			format:   FormatHTML,
+			want:     "😀 <i>hi</i> there",
+		},
+		{
+			name:     "entity covering emoji",
+			text:     "x😀y",
+			entities: []tg.MessageEntityClass{&tg.MessageEntityBold{Offset: 1, Length: 2}},
+			format:   FormatMarkup,
+			want:     "x[b]😀[/b]y",
+		},
+		{
+			name: "nested entities",
+			text: "bold italic",
+			entities: []tg.MessageEntityClass{
+				&tg.MessageEntityItalic{Offset: 5, Length: 6},
+				&tg.MessageEntityBold{Offset: 0, Length: 11},
+			},
+			format: FormatHTML,
+			want:   "<b>bold <i>italic</i></b>",
+		},
+		{
+			name: "overlapping entities are reopened",
+			text: "abcdef",
+			entities: []tg.MessageEntityClass{
+				&tg.MessageEntityBold{Offset: 0, Length: 4},
+				&tg.MessageEntityItalic{Offset: 2, Length: 4},
+			},
+			format: FormatMarkup,
+			want:   "[b]ab[i]cd[/i][/b][i]ef[/i]",
+		},
+		{
+			name:     "text url in plain",
+			text:     "see docs now",
+			entities: []tg.MessageEntityClass{&tg.MessageEntityTextURL{Offset: 4, Length: 4, URL: "https://example.com"}},
+			format:   FormatPlain,
+			want:     "see docs (https://example.com) now",
+		},
+		{
+			name: "text url in plain is printed once when split by overlap",
+			text: "abcdef",
+			entities: []tg.MessageEntityClass{
+				&tg.MessageEntityTextURL{Offset: 0, Length: 4, URL: "https://example.com"},
+				&tg.MessageEntityBold{Offset: 2, Length: 4},
+			},
+			format: FormatPlain,
+			want:   "abcd (https://example.com)ef",
+		},
+		{
+			name:     "text url in markup encodes brackets",
+			text:     "link",
+			entities: []tg.MessageEntityClass{&tg.MessageEntityTextURL{Offset: 0, Length: 4, URL: "https://e.com/[x]"}},
+			format:   FormatMarkup,
+			want:     "[url=https://e.com/%5Bx%5D]link[/url]",
+		},
+		{
+			name:     "unsafe url in html is dropped",
+			text:     "click",
+			entities: []tg.MessageEntityClass{&tg.MessageEntityTextURL{Offset: 0, Length: 5, URL: "javascript:alert(1)"}},
+			format:   FormatHTML,
+			want:     "click",
+		},
+		{
+			name:     "html link attribute is escaped",
+			text:     "a<b",
+			entities: []tg.MessageEntityClass{&tg.MessageEntityTextURL{Offset: 0, Length: 3, URL: `https://e.com/?q="x"`}},
+			format:   FormatHTML,
+			want:     `<a href="https://e.com/?q=&#34;x&#34;">a&lt;b</a>`,
+		},
+		{
+			name: "invalid entities are skipped",
+			text: "abc",
+			entities: []tg.MessageEntityClass{
+				&tg.MessageEntityBold{Offset: 2, Length: 5},
+				&tg.MessageEntityItalic{Offset: 1, Length: 0},
+			},
+			format: FormatMarkup,
+			want:   "abc",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RenderText(tt.text, tt.entities, tt.format); got != tt.want {
+				t.Errorf("RenderText() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEntityLinks(t *testing.T) {
+	text := "😀 example.com, docs and me@example.com"
+	entities := []tg.MessageEntityClass{
+		&tg.MessageEntityURL{Offset: 3, Length: 11},
+		&tg.MessageEntityTextURL{Offset: 16, Length: 4, URL: "https://docs.example.com"},
+		&tg.MessageEntityEmail{Offset: 25, Length: 14},
+		&tg.MessageEntityBold{Offset: 0, Length: 2},
+	}
+	want := []Link{
+		{URL: "http://example.com", Text: "example.com"},
+		{URL: "https://docs.example.com", Text: "docs"},
+		{URL: "mailto:me@example.com", Text: "me@example.com"},
+	}
+
+	if got := EntityLinks(text, entities); !reflect.DeepEqual(got, want) {
+		t.Errorf("EntityLinks() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,187 @@
+package telegram
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"sync"
+	"telegram-gateway/storage"
+	"time"
+
+	"github.com/gotd/td/tg"
+)
+
+// Типи подій акаунта
+const (
+	EventNewMessage     = "new_message"
+	EventEditMessage    = "edit_message"
+	EventDeleteMessages = "delete_messages"
+	EventReadInbox      = "read_inbox"
+	EventReadOutbox     = "read_outbox"
+	EventUnreadMark     = "unread_mark"
+	EventOutbox         = "outbox_status"
+)
+
+// Скільки останніх подій тримати для відновлення після обриву зв'язку
+const eventLogLimit = 500
+
+// Скільки курсорів резервує один запис у сховище
+const cursorReserve = 1000
+
+// Event - подія акаунта для /api/updates
+type Event struct {
+	Cursor      int64    `json:"cursor"`
+	Type        string   `json:"type"`
+	ChatID      string   `json:"chat_id,omitempty"`
+	Message     *Message `json:"message,omitempty"`
+	MessageIDs  []int    `json:"message_ids,omitempty"`
+	MaxID       int      `json:"max_id,omitempty"`
+	UnreadCount *int     `json:"unread_count,omitempty"`
+	Unread      *bool    `json:"unread,omitempty"`
+	// Новий стан повідомлення з черги відправки (EventOutbox)
+	Outbox *OutboxEntry `json:"outbox,omitempty"`
+	Date   time.Time    `json:"date"`
+}
+
+// EventLog - журнал подій акаунта з монотонним курсором.
+// Живе довше за з'єднання, а в сховищі зберігається межа виданих курсорів,
+// тож після перезапуску курсори не повторюються і курсор пристрою
+// лишається дійсним.
+type EventLog struct {
+	store storage.SessionStore
+	key   string
+
+	mu      sync.Mutex
+	events  []Event
+	last    int64
+	changed chan struct{}
+
+	// Курсори до reserved включно можна видавати: межа вже збережена
+	reserved  int64
+	discarded bool
+}
+
+// NewEventLog створює журнал і продовжує курсор від збереженої межі
+func NewEventLog(store storage.SessionStore, key string) *EventLog {
+	l := &EventLog{
+		store:   store,
+		key:     key,
+		changed: make(chan struct{}),
+	}
+
+	if data, err := store.Load(context.Background(), key); err == nil {
+		l.last, _ = strconv.ParseInt(string(data), 10, 64)
+		l.reserved = l.last
+	}
+	return l
+}
+
+// Append додає подію і будить усіх, хто чекає
+func (l *EventLog) Append(event Event) Event {
+	l.mu.Lock()
+	if l.last >= l.reserved {
+		l.reserve()
+	}
+	l.last++
+	event.Cursor = l.last
+	if event.Date.IsZero() {
+		event.Date = time.Now()
+	}
+
+	l.events = append(l.events, event)
+	if len(l.events) > eventLogLimit {
+		l.events = l.events[len(l.events)-eventLogLimit:]
+	}
+
+	close(l.changed)
+	l.changed = make(chan struct{})
+	l.mu.Unlock()
+
+	return event
+}
+
+// reserve зберігає нову межу курсорів, перш ніж їх видавати; викликається
+// під l.mu. Якщо зберегти не вдалося, спроба повториться з наступною подією.
+func (l *EventLog) reserve() {
+	if l.discarded {
+		return
+	}
+	reserved := l.last + cursorReserve
+	if err := l.store.Save(context.Background(), l.key, []byte(strconv.FormatInt(reserved, 10))); err != nil {
+		log.Printf("Events: Failed to save cursor %s: %v", l.key, err)
+		return
+	}
+	l.reserved = reserved
+}
+
+// Since повертає події з курсором більшим за cursor.
+// reset=true означає, що частина подій після cursor вже втрачена
+// і клієнту треба перечитати список чатів.
+func (l *EventLog) Since(cursor int64, limit int) (events []Event, reset bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	oldest := l.last + 1
+	if len(l.events) > 0 {
+		oldest = l.events[0].Cursor
+	}
+	reset = cursor+1 < oldest || cursor > l.last
+
+	for _, event := range l.events {
+		if event.Cursor <= cursor && !reset {
+			continue
+		}
+		events = append(events, event)
+		if limit > 0 && len(events) >= limit {
+			break
+		}
+	}
+	return events, reset
+}
+
+// discard зупиняє збереження курсора (журнал акаунта видалено)
+func (l *EventLog) discard() {
+	l.mu.Lock()
+	l.discarded = true
+	l.mu.Unlock()
+}
+
+// Last повертає курсор останньої події
+func (l *EventLog) Last() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.last
+}
+
+// Changed повертає канал, який закриється при наступній події
+func (l *EventLog) Changed() <-chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.changed
+}
+
+// Events повертає журнал подій акаунта (nil, якщо клієнт не з пулу)
+func (c *Client) Events() *EventLog {
+	return c.events
+}
+
+// emit додає подію в журнал акаунта
+func (c *Client) emit(event Event) {
+	c.invalidateUnread()
+	c.invalidateDialogs()
+	if c.events != nil {
+		c.events.Append(event)
+	}
+}
+
+// dialogPeerChatID повертає позначений ID чату з DialogPeer
+func dialogPeerChatID(peer tg.DialogPeerClass) (int64, bool) {
+	if p, ok := peer.(*tg.DialogPeer); ok {
+		return MarkedPeerID(p.Peer), true
+	}
+	return 0, false
+}
+
+func formatChatID(chatID int64) string {
+	return strconv.FormatInt(chatID, 10)
+}
@@ -0,0 +1,249 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/gotd/td/tg"
+)
+
+// ErrFolderNotFound повертається для невідомої теки
+var ErrFolderNotFound = errors.New("folder not found")
+
+// Скільки діалогів переглядається для відбору в теку користувача
+const folderScanLimit = 500
+
+// Folder - тека чатів: основний список, архів або тека користувача
+type Folder struct {
+	ID    int    `json:"id"`
+	Title string `json:"title"`
+	Type  string `json:"type"` // "main", "archive", "filter", "chatlist"
+}
+
+// dialogFilter - правила відбору чатів у теку користувача (messages.getDialogFilters)
+type dialogFilter struct {
+	contacts, nonContacts, groups, broadcasts, bots bool
+	excludeMuted, excludeRead, excludeArchived      bool
+
+	pinned  []int64
+	include map[int64]bool
+	exclude map[int64]bool
+}
+
+// GetFolders повертає основний список, архів і теки користувача
+func (c *Client) GetFolders(ctx context.Context) ([]Folder, error) {
+	folders := []Folder{
+		{ID: 0, Title: "Чати", Type: "main"},
+		{ID: ArchiveFolderID, Title: "Архів", Type: "archive"},
+	}
+
+	err := c.run(ctx, func(ctx context.Context) error {
+		result, err := c.Client.API().MessagesGetDialogFilters(ctx)
+		if err != nil {
+			return fmt.Errorf("get dialog filters error: %w", err)
+		}
+
+		for _, f := range result.Filters {
+			switch filter := f.(type) {
+			case *tg.DialogFilter:
+				folders = append(folders, Folder{ID: filter.ID, Title: filter.Title.Text, Type: "filter"})
+			case *tg.DialogFilterChatlist:
+				folders = append(folders, Folder{ID: filter.ID, Title: filter.Title.Text, Type: "chatlist"})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return folders, nil
+}
+
+// GetFolderDialogsPage отримує сторінку діалогів теки користувача filterID.
+// Telegram не віддає такі теки окремо, тож діалоги відбираються з перших
+// folderScanLimit чатів; cursor.Seen - зсув у відібраному списку.
+func (c *Client) GetFolderDialogsPage(ctx context.Context, filterID, limit int, cursor *DialogCursor) (*DialogsPage, error) {
+	filter, err := c.dialogFilter(ctx, filterID)
+	if err != nil {
+		return nil, err
+	}
+
+	var all []Dialog
+	var scan *DialogCursor
+	var version int64
+	for len(all) < folderScanLimit {
+		page, err := c.GetDialogsPage(ctx, AllFolders, 100, scan)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, page.Dialogs...)
+		version = page.Version
+		if page.NextCursor == nil {
+			break
+		}
+		scan = page.NextCursor
+	}
+
+	matched := filter.apply(all)
+
+	offset := 0
+	if cursor != nil {
+		offset = cursor.Seen
+	}
+	if offset > len(matched) {
+		offset = len(matched)
+	}
+	end := offset + limit
+	if end > len(matched) {
+		end = len(matched)
+	}
+
+	page := &DialogsPage{
+		Dialogs: matched[offset:end],
+		Total:   len(matched),
+		Version: version,
+	}
+	if end < len(matched) {
+		page.NextCursor = &DialogCursor{Seen: end}
+	}
+	return page, nil
+}
+
+// dialogFilter завантажує правила теки користувача
+func (c *Client) dialogFilter(ctx context.Context, filterID int) (*dialogFilter, error) {
+	var filter *dialogFilter
+
+	err := c.run(ctx, func(ctx context.Context) error {
+		result, err := c.Client.API().MessagesGetDialogFilters(ctx)
+		if err != nil {
+			return fmt.Errorf("get dialog filters error: %w", err)
+		}
+
+		for _, f := range result.Filters {
+			switch rules := f.(type) {
+			case *tg.DialogFilter:
+				if rules.ID != filterID {
+					continue
+				}
+				filter = &dialogFilter{
+					contacts:        rules.Contacts,
+					nonContacts:     rules.NonContacts,
+					groups:          rules.Groups,
+					broadcasts:      rules.Broadcasts,
+					bots:            rules.Bots,
+					excludeMuted:    rules.ExcludeMuted,
+					excludeRead:     rules.ExcludeRead,
+					excludeArchived: rules.ExcludeArchived,
+				}
+				filter.setPeers(c.selfID(), rules.PinnedPeers, rules.IncludePeers, rules.ExcludePeers)
+			case *tg.DialogFilterChatlist:
+				if rules.ID != filterID {
+					continue
+				}
+				filter = &dialogFilter{}
+				filter.setPeers(c.selfID(), rules.PinnedPeers, rules.IncludePeers, nil)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if filter == nil {
+		return nil, ErrFolderNotFound
+	}
+	return filter, nil
+}
+
+func (f *dialogFilter) setPeers(self int64, pinned, include, exclude []tg.InputPeerClass) {
+	f.include = make(map[int64]bool)
+	f.exclude = make(map[int64]bool)
+	for _, peer := range pinned {
+		if id, ok := InputPeerID(peer, self); ok {
+			f.pinned = append(f.pinned, id)
+			f.include[id] = true
+		}
+	}
+	for _, peer := range include {
+		if id, ok := InputPeerID(peer, self); ok {
+			f.include[id] = true
+		}
+	}
+	for _, peer := range exclude {
+		if id, ok := InputPeerID(peer, self); ok {
+			f.exclude[id] = true
+		}
+	}
+}
+
+// apply відбирає діалоги теки: спершу закріплені в ній, далі решта в порядку Telegram
+func (f *dialogFilter) apply(dialogs []Dialog) []Dialog {
+	byID := make(map[int64]Dialog, len(dialogs))
+	for _, d := range dialogs {
+		byID[d.ID] = d
+	}
+
+	var result []Dialog
+	for _, id := range f.pinned {
+		if d, ok := byID[id]; ok {
+			d.Pinned = true
+			d.PinnedOrder = len(result) + 1
+			result = append(result, d)
+		}
+	}
+
+	pinned := make(map[int64]bool, len(f.pinned))
+	for _, id := range f.pinned {
+		pinned[id] = true
+	}
+	for _, d := range dialogs {
+		if pinned[d.ID] || !f.match(d) {
+			continue
+		}
+		// Закріплення в основному списку до теки не стосується
+		d.Pinned = false
+		d.PinnedOrder = 0
+		result = append(result, d)
+	}
+	return result
+}
+
+// match перевіряє діалог за правилами теки (як у офіційних клієнтах)
+func (f *dialogFilter) match(d Dialog) bool {
+	if f.exclude[d.ID] {
+		return false
+	}
+	// Явно додані чати показуються незалежно від решти правил
+	if f.include[d.ID] {
+		return true
+	}
+
+	var category bool
+	switch {
+	case d.Type == "user" && d.bot:
+		category = f.bots
+	case d.Type == "user" && d.contact:
+		category = f.contacts
+	case d.Type == "user":
+		category = f.nonContacts
+	case d.Type == "channel" && d.broadcast:
+		category = f.broadcasts
+	case d.Type == "chat" || d.Type == "channel":
+		category = f.groups
+	}
+	if !category {
+		return false
+	}
+
+	if f.excludeMuted && d.Muted {
+		return false
+	}
+	if f.excludeRead && d.UnreadCount == 0 && !d.unreadMark {
+		return false
+	}
+	if f.excludeArchived && d.FolderID == ArchiveFolderID {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,529 @@
+package telegram
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"telegram-gateway/config"
+	"telegram-gateway/storage"
+	"time"
+
+	"github.com/gotd/td/telegram/auth"
+	"github.com/gotd/td/telegram/auth/qrlogin"
+	"github.com/gotd/td/tg"
+)
+
+// LoginState - стан спроби входу
+type LoginState string
+
+const (
+	LoginCodeSent         LoginState = "code_sent"
+	LoginCodeSubmitted    LoginState = "code_submitted"
+	LoginPasswordRequired LoginState = "password_required"
+	LoginSignUpRequired   LoginState = "signup_required"
+	LoginQRPending        LoginState = "qr_pending"
+	LoginAuthorized       LoginState = "authorized"
+	LoginFailed           LoginState = "failed"
+	LoginExpired          LoginState = "expired"
+)
+
+var (
+	// ErrLoginNotFound - спроби входу з таким ID немає (або вона вже завершилась)
+	ErrLoginNotFound = errors.New("login attempt not found")
+	// ErrLoginState - дія недоступна в поточному стані спроби
+	ErrLoginState = errors.New("action is not allowed in current login state")
+)
+
+// Finished повідомляє, чи спроба входу завершилась
+func (s LoginState) Finished() bool {
+	return s == LoginAuthorized || s == LoginFailed || s == LoginExpired
+}
+
+// LoginAttempt - одна спроба входу за номером телефону.
+// Стан змінюють лише колбеки AuthHandler і результат авторизації.
+type LoginAttempt struct {
+	ID        string
+	Phone     string
+	Client    *Client
+	CreatedAt time.Time
+
+	mu         sync.Mutex
+	state      LoginState
+	err        error
+	sentCode   *SentCode
+	terms      *TermsOfService
+	qrToken    *qrlogin.Token
+	updatedAt  time.Time
+	changed    chan struct{}
+	codeCh     chan string
+	passwordCh chan string
+	signUpCh   chan auth.UserInfo
+	cancel     context.CancelFunc
+}
+
+// State повертає поточний стан і помилку (для failed)
+func (a *LoginAttempt) State() (LoginState, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.state, a.err
+}
+
+// SentCode повертає відомості про останній надісланий код
+func (a *LoginAttempt) SentCode() *SentCode {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.sentCode == nil {
+		return &SentCode{}
+	}
+	code := *a.sentCode
+	return &code
+}
+
+func (a *LoginAttempt) setSentCode(code *SentCode) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.sentCode = code
+}
+
+// Terms повертає умови використання для нового акаунта (signup_required)
+func (a *LoginAttempt) Terms() *TermsOfService {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.terms
+}
+
+func (a *LoginAttempt) setTerms(terms *TermsOfService) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.terms = terms
+}
+
+// QRToken повертає поточний QR-токен (nil, якщо це вхід за кодом)
+func (a *LoginAttempt) QRToken() *qrlogin.Token {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.qrToken == nil {
+		return nil
+	}
+	token := *a.qrToken
+	return &token
+}
+
+// setQRToken показує новий токен. Оновлення токена не продовжує життя
+// спроби - його продовжує лише клієнт, що чекає на вхід (Touch).
+func (a *LoginAttempt) setQRToken(token qrlogin.Token) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.state != "" && a.state != LoginQRPending {
+		return
+	}
+	a.qrToken = &token
+	a.state = LoginQRPending
+	close(a.changed)
+	a.changed = make(chan struct{})
+}
+
+// Changed повертає канал, що закривається при наступній зміні стану чи токена
+func (a *LoginAttempt) Changed() <-chan struct{} {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.changed
+}
+
+// Touch продовжує життя спроби
+func (a *LoginAttempt) Touch() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.updatedAt = time.Now()
+}
+
+// setState змінює стан і будить усіх, хто його чекає
+func (a *LoginAttempt) setState(state LoginState, err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.state.Finished() {
+		return
+	}
+	a.state = state
+	a.err = err
+	a.updatedAt = time.Now()
+	close(a.changed)
+	a.changed = make(chan struct{})
+}
+
+// transition атомарно переводить спробу зі стану from у to
+func (a *LoginAttempt) transition(from, to LoginState) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.state != from {
+		return fmt.Errorf("%w: %s", ErrLoginState, a.state)
+	}
+	a.state = to
+	a.updatedAt = time.Now()
+	close(a.changed)
+	a.changed = make(chan struct{})
+	return nil
+}
+
+// wait чекає, поки стан перестане бути одним із states
+func (a *LoginAttempt) wait(ctx context.Context, states ...LoginState) (LoginState, error) {
+	for {
+		a.mu.Lock()
+		state, err, changed := a.state, a.err, a.changed
+		a.mu.Unlock()
+
+		pending := false
+		for _, s := range states {
+			if state == s {
+				pending = true
+				break
+			}
+		}
+		if !pending {
+			return state, err
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return state, ctx.Err()
+		}
+	}
+}
+
+// rejected повертає поточний стан; для незавершеної спроби - разом з ErrLoginState
+func (a *LoginAttempt) rejected(err error) (LoginState, error) {
+	state, stateErr := a.State()
+	if state.Finished() {
+		return state, stateErr
+	}
+	return state, err
+}
+
+// floodWait повертає помилку FLOOD_WAIT, якщо час очікування ще не минув
+func (a *LoginAttempt) floodWait() (LoginState, *AuthError) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var authErr *AuthError
+	if errors.As(a.err, &authErr) && authErr.Code == AuthErrFloodWait && authErr.RetryAfter() > 0 {
+		return a.state, authErr
+	}
+	return a.state, nil
+}
+
+// SubmitCode передає код і чекає, чим закінчиться його перевірка
+func (a *LoginAttempt) SubmitCode(ctx context.Context, code string) (LoginState, error) {
+	if state, err := a.floodWait(); err != nil {
+		return state, err
+	}
+	if err := a.transition(LoginCodeSent, LoginCodeSubmitted); err != nil {
+		return a.rejected(err)
+	}
+	a.codeCh <- code
+	return a.wait(ctx, LoginCodeSubmitted)
+}
+
+// SubmitPassword передає пароль 2FA і чекає результату
+func (a *LoginAttempt) SubmitPassword(ctx context.Context, password string) (LoginState, error) {
+	if state, err := a.floodWait(); err != nil {
+		return state, err
+	}
+	if err := a.transition(LoginPasswordRequired, LoginCodeSubmitted); err != nil {
+		return a.rejected(err)
+	}
+	a.passwordCh <- password
+	return a.wait(ctx, LoginCodeSubmitted)
+}
+
+// SubmitSignUp передає ім'я нового користувача і чекає реєстрації.
+// Умови використання мають бути прийняті до виклику.
+func (a *LoginAttempt) SubmitSignUp(ctx context.Context, firstName, lastName string) (LoginState, error) {
+	if state, err := a.floodWait(); err != nil {
+		return state, err
+	}
+	if err := a.transition(LoginSignUpRequired, LoginCodeSubmitted); err != nil {
+		return a.rejected(err)
+	}
+	a.signUpCh <- auth.UserInfo{FirstName: firstName, LastName: lastName}
+	return a.wait(ctx, LoginCodeSubmitted)
+}
+
+// ResendCode просить Telegram надіслати код ще раз (способом next_type).
+// Попередня помилка (наприклад, code_expired) скидається.
+func (a *LoginAttempt) ResendCode(ctx context.Context) (*SentCode, error) {
+	if _, err := a.floodWait(); err != nil {
+		return nil, err
+	}
+	if state, _ := a.State(); state != LoginCodeSent {
+		return nil, fmt.Errorf("%w: %s", ErrLoginState, state)
+	}
+
+	// Спроба в стані code_sent, отже її з'єднання активне
+	sent, err := a.Client.Client.API().AuthResendCode(ctx, &tg.AuthResendCodeRequest{
+		PhoneNumber:   a.Phone,
+		PhoneCodeHash: a.SentCode().hash,
+	})
+	if err != nil {
+		authErr := AsAuthError(err)
+		if authErr.Code == AuthErrFloodWait {
+			a.resent(nil, authErr)
+		}
+		return nil, authErr
+	}
+
+	sentCode, ok := sent.(*tg.AuthSentCode)
+	if !ok {
+		return nil, fmt.Errorf("unexpected sent code type: %T", sent)
+	}
+	code := newSentCode(sentCode)
+	if !a.resent(code, nil) {
+		state, _ := a.State()
+		return nil, fmt.Errorf("%w: %s", ErrLoginState, state)
+	}
+	return a.SentCode(), nil
+}
+
+// resent оновлює код і помилку, якщо спроба досі чекає на код
+func (a *LoginAttempt) resent(code *SentCode, err error) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.state != LoginCodeSent {
+		return false
+	}
+	if code != nil {
+		a.sentCode = code
+	}
+	a.err = err
+	a.updatedAt = time.Now()
+	close(a.changed)
+	a.changed = make(chan struct{})
+	return true
+}
+
+// LoginManager веде спроби входу і прибирає покинуті
+type LoginManager struct {
+	cfg   *config.Config
+	store storage.SessionStore
+
+	mu       sync.Mutex
+	attempts map[string]*LoginAttempt
+	byPhone  map[string]string
+}
+
+// NewLoginManager створює менеджер входу
+func NewLoginManager(cfg *config.Config, store storage.SessionStore) *LoginManager {
+	return &LoginManager{
+		cfg:      cfg,
+		store:    store,
+		attempts: make(map[string]*LoginAttempt),
+		byPhone:  make(map[string]string),
+	}
+}
+
+// LoginSessionKey повертає ключ сесії спроби входу в сховищі
+func LoginSessionKey(loginID string) string {
+	return "login:" + loginID
+}
+
+func newLoginID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// Start починає вхід: надсилає код і повертається, коли Telegram
+// підтвердив відправку (code_sent) або відхилив запит (failed)
+func (m *LoginManager) Start(ctx context.Context, phone string) (*LoginAttempt, error) {
+	return m.start(ctx, phone, func(ctx context.Context, client *Client, handler *AuthHandler) error {
+		return client.Auth(ctx, handler)
+	})
+}
+
+// StartQR починає вхід за QR-кодом і повертається, коли перший
+// токен експортовано (qr_pending) або Telegram відхилив запит (failed)
+func (m *LoginManager) StartQR(ctx context.Context) (*LoginAttempt, error) {
+	return m.start(ctx, "", func(ctx context.Context, client *Client, handler *AuthHandler) error {
+		return client.QRAuth(ctx, handler)
+	})
+}
+
+func (m *LoginManager) start(ctx context.Context, phone string, run func(ctx context.Context, client *Client, handler *AuthHandler) error) (*LoginAttempt, error) {
+	id, err := newLoginID()
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := NewClient(m.cfg, m.store, LoginSessionKey(id))
+	if err != nil {
+		return nil, err
+	}
+
+	runCtx, cancel := context.WithCancel(context.Background())
+	attempt := &LoginAttempt{
+		ID:         id,
+		Phone:      phone,
+		Client:     client,
+		CreatedAt:  time.Now(),
+		updatedAt:  time.Now(),
+		changed:    make(chan struct{}),
+		codeCh:     make(chan string, 1),
+		passwordCh: make(chan string, 1),
+		signUpCh:   make(chan auth.UserInfo, 1),
+		cancel:     cancel,
+	}
+
+	// Попередня незавершена спроба для цього номера більше не потрібна
+	m.mu.Lock()
+	previous := ""
+	m.attempts[id] = attempt
+	if phone != "" {
+		previous = m.byPhone[phone]
+		m.byPhone[phone] = id
+	}
+	m.mu.Unlock()
+	if previous != "" {
+		m.Remove(previous)
+	}
+
+	go func() {
+		err := run(runCtx, client, &AuthHandler{PhoneNumber: phone, attempt: attempt})
+		if err != nil {
+			if runCtx.Err() != nil {
+				attempt.setState(LoginExpired, err)
+			} else {
+				log.Printf("Login: Attempt %s failed: %v", id, err)
+				attempt.setState(LoginFailed, AsAuthError(err))
+			}
+			return
+		}
+		attempt.setState(LoginAuthorized, nil)
+	}()
+
+	// Чекаємо, поки AuthHandler підтвердить відправку коду чи експорт QR-токена
+	state, err := attempt.wait(ctx, "")
+	if err != nil && !state.Finished() {
+		m.Remove(id)
+		return nil, err
+	}
+	if state == LoginFailed {
+		m.Remove(id)
+		return nil, err
+	}
+
+	return attempt, nil
+}
+
+// Get повертає спробу за ID
+func (m *LoginManager) Get(id string) (*LoginAttempt, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	attempt, exists := m.attempts[id]
+	if !exists {
+		return nil, ErrLoginNotFound
+	}
+	return attempt, nil
+}
+
+// GetByPhone повертає останню спробу для номера (для клієнтів без login_id)
+func (m *LoginManager) GetByPhone(phone string) (*LoginAttempt, error) {
+	m.mu.Lock()
+	id := m.byPhone[phone]
+	m.mu.Unlock()
+
+	if id == "" {
+		return nil, ErrLoginNotFound
+	}
+	return m.Get(id)
+}
+
+// Remove завершує спробу і видаляє її тимчасову сесію
+func (m *LoginManager) Remove(id string) {
+	m.mu.Lock()
+	attempt, exists := m.attempts[id]
+	if exists {
+		delete(m.attempts, id)
+		if m.byPhone[attempt.Phone] == id {
+			delete(m.byPhone, attempt.Phone)
+		}
+	}
+	m.mu.Unlock()
+
+	if !exists {
+		return
+	}
+
+	attempt.cancel()
+	attempt.setState(LoginExpired, nil)
+	if err := m.store.Delete(context.Background(), LoginSessionKey(id)); err != nil {
+		log.Printf("Login: Failed to delete session of attempt %s: %v", id, err)
+	}
+}
+
+// Cancel скасовує спробу входу; надісланий код стає недійсним (auth.cancelCode)
+func (m *LoginManager) Cancel(ctx context.Context, id string) error {
+	attempt, err := m.Get(id)
+	if err != nil {
+		return err
+	}
+
+	if state, _ := attempt.State(); state == LoginCodeSent {
+		if _, err := attempt.Client.Client.API().AuthCancelCode(ctx, &tg.AuthCancelCodeRequest{
+			PhoneNumber:   attempt.Phone,
+			PhoneCodeHash: attempt.SentCode().hash,
+		}); err != nil {
+			log.Printf("Login: Failed to cancel code of attempt %s: %v", id, err)
+		}
+	}
+
+	m.Remove(id)
+	return nil
+}
+
+// RunCleanup періодично прибирає покинуті спроби входу (LOGIN_TIMEOUT)
+func (m *LoginManager) RunCleanup(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.cleanup()
+		}
+	}
+}
+
+func (m *LoginManager) cleanup() {
+	deadline := time.Now().Add(-m.cfg.LoginTimeout)
+
+	var expired []string
+	m.mu.Lock()
+	for id, attempt := range m.attempts {
+		attempt.mu.Lock()
+		if attempt.updatedAt.Before(deadline) {
+			expired = append(expired, id)
+		}
+		attempt.mu.Unlock()
+	}
+	m.mu.Unlock()
+
+	for _, id := range expired {
+		log.Printf("Login: Attempt %s expired", id)
+		m.Remove(id)
+	}
+}
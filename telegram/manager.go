@@ -0,0 +1,315 @@
+package telegram
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"telegram-gateway/config"
+	"telegram-gateway/storage"
+	"time"
+
+	"github.com/gotd/td/telegram/auth"
+)
+
+// Manager тримає по одному довгоживучому з'єднанню на акаунт
+// і закриває з'єднання, які не використовувались довше SessionTimeout
+type Manager struct {
+	cfg   *config.Config
+	store storage.SessionStore
+
+	mu      sync.Mutex
+	entries map[string]*managedClient
+
+	// Журнали подій живуть довше за з'єднання
+	logsMu sync.Mutex
+	logs   map[string]*EventLog
+
+	// Черги відправки (див. outbox.go): акаунти з непорожньою чергою,
+	// повідомлення, що надсилаються зараз, і акаунти, дані яких видаляє Purge
+	outboxMu      sync.Mutex
+	outboxKeys    map[string]bool
+	outboxBusy    map[string]bool
+	outboxPurging map[string]int
+}
+
+type managedClient struct {
+	client *Client
+	ready  chan struct{}
+	err    error
+}
+
+// NewManager створює менеджер з'єднань, сесії якого зберігаються в store
+func NewManager(cfg *config.Config, store storage.SessionStore) *Manager {
+	return &Manager{
+		cfg:     cfg,
+		store:   store,
+		entries: make(map[string]*managedClient),
+		logs:    make(map[string]*EventLog),
+
+		outboxKeys:    make(map[string]bool),
+		outboxBusy:    make(map[string]bool),
+		outboxPurging: make(map[string]int),
+	}
+}
+
+// Префікси ключів MTProto сесій у сховищі
+var sessionKeyPrefixes = []string{"account:", "legacy:"}
+
+// SessionKey повертає ключ сесії акаунта шлюзу в сховищі
+func SessionKey(accountID string) string {
+	return "account:" + accountID
+}
+
+// LegacySessionKey будує ключ для клієнтів, що передають сесію в X-Session-Data
+func LegacySessionKey(phone, sessionData string) string {
+	sum := sha256.Sum256([]byte(sessionData))
+	return "legacy:" + phone + ":" + hex.EncodeToString(sum[:8])
+}
+
+// Acquire повертає активний клієнт для сесії з ключем key,
+// за потреби піднімаючи нове з'єднання
+func (m *Manager) Acquire(ctx context.Context, key string) (*Client, error) {
+	for {
+		m.mu.Lock()
+		entry, exists := m.entries[key]
+		if !exists {
+			entry = &managedClient{ready: make(chan struct{})}
+			m.entries[key] = entry
+			m.mu.Unlock()
+
+			entry.client, entry.err = m.connect(ctx, key)
+			close(entry.ready)
+			if entry.err != nil {
+				m.remove(key, entry)
+				return nil, entry.err
+			}
+
+			log.Printf("Manager: Connected account %s (active: %d)", key, m.Count())
+			return entry.client, nil
+		}
+		m.mu.Unlock()
+
+		select {
+		case <-entry.ready:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+
+		if entry.err == nil && entry.client.Alive() {
+			entry.client.Touch()
+			return entry.client, nil
+		}
+
+		// З'єднання впало - прибираємо і пробуємо ще раз
+		m.remove(key, entry)
+	}
+}
+
+// connect створює клієнт і перевіряє, що сесія авторизована
+func (m *Manager) connect(ctx context.Context, key string) (*Client, error) {
+	// Без збереженої сесії нема чого підключати
+	if _, err := m.store.Load(ctx, key); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, ErrNotAuthorized
+		}
+		return nil, fmt.Errorf("%w: failed to load session: %w", ErrSessionStore, err)
+	}
+
+	client, err := NewClient(m.cfg, m.store, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := client.Start(ctx); err != nil {
+		return nil, err
+	}
+
+	status, err := client.Client.Auth().Status(ctx)
+	if err != nil && !auth.IsUnauthorized(err) {
+		client.Stop()
+		return nil, fmt.Errorf("auth status error: %w", err)
+	}
+	if err != nil || !status.Authorized {
+		// Акаунт вийшов або ключ відкликано - сесія більше не потрібна
+		client.Stop()
+		m.forgetSession(key)
+		return nil, ErrNotAuthorized
+	}
+
+	if user, ok := status.User.AsNotEmpty(); ok {
+		client.setSelf(user)
+	}
+	client.events = m.eventLog(key)
+
+	// Оновлення надходять через це ж з'єднання, поки акаунт активний
+	if err := client.StartUpdatesListener(); err != nil {
+		client.Stop()
+		return nil, fmt.Errorf("failed to start updates: %w", err)
+	}
+
+	return client, nil
+}
+
+// Remove закриває з'єднання акаунта
+func (m *Manager) Remove(key string) {
+	m.mu.Lock()
+	entry, exists := m.entries[key]
+	m.mu.Unlock()
+
+	if exists {
+		m.remove(key, entry)
+	}
+}
+
+// remove видаляє запис, якщо він досі відповідає ключу, і закриває клієнт
+func (m *Manager) remove(key string, entry *managedClient) {
+	m.mu.Lock()
+	if m.entries[key] == entry {
+		delete(m.entries, key)
+	}
+	m.mu.Unlock()
+
+	<-entry.ready
+	if entry.client != nil {
+		entry.client.Stop()
+	}
+}
+
+// Count повертає кількість активних з'єднань
+func (m *Manager) Count() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.entries)
+}
+
+// RunCleanup періодично закриває з'єднання, що простоюють довше SessionTimeout
+func (m *Manager) RunCleanup(ctx context.Context) {
+	ticker := time.NewTicker(m.cfg.CleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.cleanup()
+		}
+	}
+}
+
+// eventLog повертає журнал подій акаунта, створюючи його за потреби
+func (m *Manager) eventLog(key string) *EventLog {
+	m.logsMu.Lock()
+	defer m.logsMu.Unlock()
+
+	l, exists := m.logs[key]
+	if !exists {
+		l = NewEventLog(m.store, "cursor:"+key)
+		m.logs[key] = l
+	}
+	return l
+}
+
+// Purge закриває з'єднання і видаляє всі дані акаунта в шлюзі:
+// сесію, стан потоку оновлень, журнал подій, журнал надісланих повідомлень
+// і чергу відправки
+func (m *Manager) Purge(key string) {
+	m.Remove(key)
+
+	m.logsMu.Lock()
+	if l, exists := m.logs[key]; exists {
+		l.discard()
+	}
+	delete(m.logs, key)
+	m.logsMu.Unlock()
+
+	// Поки дані видаляються, черга акаунта нічого не записує (див. outbox.go)
+	m.outboxMu.Lock()
+	delete(m.outboxKeys, key)
+	m.outboxPurging[key]++
+	m.outboxMu.Unlock()
+
+	for _, k := range []string{key, UpdateStateKey(key), "cursor:" + key, SentKey(key), OutboxKey(key)} {
+		if err := m.store.Delete(context.Background(), k); err != nil {
+			log.Printf("Manager: Failed to delete %s: %v", k, err)
+		}
+	}
+
+	m.outboxMu.Lock()
+	if m.outboxPurging[key]--; m.outboxPurging[key] == 0 {
+		delete(m.outboxPurging, key)
+	}
+	m.outboxMu.Unlock()
+}
+
+// forgetSession видаляє збережену сесію акаунта
+func (m *Manager) forgetSession(key string) {
+	if err := m.store.Delete(context.Background(), key); err != nil {
+		log.Printf("Manager: Failed to delete session %s: %v", key, err)
+	}
+}
+
+// Active повідомляє, чи є для ключа відкрите з'єднання
+func (m *Manager) Active(key string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, exists := m.entries[key]
+	return exists
+}
+
+func (m *Manager) cleanup() {
+	deadline := time.Now().Add(-m.cfg.SessionTimeout)
+
+	var idle []string
+	m.mu.Lock()
+	for key, entry := range m.entries {
+		select {
+		case <-entry.ready:
+		default:
+			// Ще підключається
+			continue
+		}
+		if entry.err != nil || !entry.client.Alive() || entry.client.LastUsed().Before(deadline) {
+			idle = append(idle, key)
+		}
+	}
+	m.mu.Unlock()
+
+	for _, key := range idle {
+		log.Printf("Manager: Evicting idle account %s", key)
+		m.Remove(key)
+	}
+
+	// Прибираємо сесії, якими давно не користувались (див. SessionKey і
+	// LegacySessionKey); інші записи сховища мають власні терміни
+	removed, err := storage.Cleanup(context.Background(), m.store, sessionKeyPrefixes, m.cfg.SessionTTL, m.Active)
+	if err != nil {
+		log.Printf("Manager: Session cleanup failed: %v", err)
+		return
+	}
+	for _, key := range removed {
+		// Без сесії стан оновлень, журнали і черга акаунта вже не потрібні
+		m.Purge(key)
+	}
+	if len(removed) > 0 {
+		log.Printf("Manager: Removed %d expired sessions", len(removed))
+	}
+}
+
+// Close закриває всі з'єднання
+func (m *Manager) Close() {
+	m.mu.Lock()
+	keys := make([]string, 0, len(m.entries))
+	for key := range m.entries {
+		keys = append(keys, key)
+	}
+	m.mu.Unlock()
+
+	for _, key := range keys {
+		m.Remove(key)
+	}
+}
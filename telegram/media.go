@@ -11,7 +11,7 @@ import (
 func (c *Client) GetPhotoData(ctx context.Context, messageID int, chatID int64) ([]byte, error) {
 	var photoData []byte
 
-	err := c.Client.Run(ctx, func(ctx context.Context) error {
+	err := c.run(ctx, func(ctx context.Context) error {
 		api := c.Client.API()
 
 		// Створюємо InputPeer для чату
@@ -20,10 +20,17 @@ func (c *Client) GetPhotoData(ctx context.Context, messageID int, chatID int64)
 			return fmt.Errorf("get input peer error: %w", err)
 		}
 
-		// Отримуємо повідомлення
-		result, err := api.MessagesGetMessages(ctx, []tg.InputMessageClass{
-			&tg.InputMessageID{ID: messageID},
-		})
+		// Отримуємо повідомлення (для каналів - окремий метод)
+		ids := []tg.InputMessageClass{&tg.InputMessageID{ID: messageID}}
+		var result tg.MessagesMessagesClass
+		if channel, ok := InputChannel(peer); ok {
+			result, err = api.ChannelsGetMessages(ctx, &tg.ChannelsGetMessagesRequest{
+				Channel: channel,
+				ID:      ids,
+			})
+		} else {
+			result, err = api.MessagesGetMessages(ctx, ids)
+		}
 		if err != nil {
 			return fmt.Errorf("get message error: %w", err)
 		}
@@ -48,6 +55,8 @@ func (c *Client) GetPhotoData(ctx context.Context, messageID int, chatID int64)
 			return fmt.Errorf("unexpected messages type: %T", result)
 		}
 
+		c.peers.Apply(messages.Users, messages.Chats)
+
 		if len(messages.Messages) == 0 {
 			return fmt.Errorf("message not found")
 		}
@@ -125,7 +134,6 @@ func (c *Client) GetPhotoData(ctx context.Context, messageID int, chatID int64)
 		}
 
 		photoData = buffer
-		_ = peer // уникаємо помилки про unused variable
 
 		return nil
 	})
@@ -3,10 +3,13 @@ package telegram
 import (
 	"context"
 	"fmt"
+	"log"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
 )
 
 type Message struct {
@@ -20,14 +23,80 @@ type Message struct {
 	Out       bool      `json:"out"`
 	HasPhoto  bool      `json:"has_photo"`
 	PhotoID   int64     `json:"photo_id,omitempty"`
+
+	ReplyTo  *ReplyInfo   `json:"reply_to,omitempty"`
+	Forward  *ForwardInfo `json:"forward,omitempty"`
+	Edited   bool         `json:"edited"`
+	EditedAt *time.Time   `json:"edited_at,omitempty"`
+	ViaBot   string       `json:"via_bot,omitempty"`
+	Links    []Link       `json:"links,omitempty"`
+
+	// Текст без підстановок і його сутності (див. Formatted)
+	raw      string
+	entities []tg.MessageEntityClass
+}
+
+// ReplyInfo - повідомлення, на яке відповідають
+type ReplyInfo struct {
+	MessageID int `json:"message_id"`
+	// Заповнений, якщо відповідь на повідомлення з іншого чату
+	ChatID string `json:"chat_id,omitempty"`
+	// Початок тексту (або цитата); порожній, якщо повідомлення недоступне
+	Text   string `json:"text,omitempty"`
+	Sender string `json:"sender,omitempty"`
+}
+
+// ForwardInfo - звідки переслано повідомлення
+type ForwardInfo struct {
+	From string    `json:"from"`
+	Date time.Time `json:"date"`
+}
+
+// Скільки символів тексту показується в ReplyInfo
+const replySnippetLength = 60
+
+// IsInChat перевіряє, чи належить повідомлення чату chatID
+func (m Message) IsInChat(chatID int64) bool {
+	id, err := strconv.ParseInt(m.ChatID, 10, 64)
+	return err == nil && SameChat(id, chatID)
+}
+
+// HistoryQuery - параметри вибірки історії чату. З BeforeID, AfterID і
+// AroundID задається щонайбільше один; нульові значення не враховуються.
+type HistoryQuery struct {
+	Limit int
+	// Повідомлення, старіші за BeforeID
+	BeforeID int
+	// Повідомлення, новіші за AfterID
+	AfterID int
+	// Повідомлення навколо AroundID (включно з ним)
+	AroundID int
+	// Повідомлення, надіслані раніше за цей час (unix)
+	OffsetDate int
+}
+
+// HistoryPage - сторінка історії чату (від нових до старих)
+type HistoryPage struct {
+	Messages     []Message
+	HasMoreOlder bool
+	HasMoreNewer bool
 }
 
-// GetMessages отримує повідомлення з чату
+// GetMessages отримує останні limit повідомлень з чату
 func (c *Client) GetMessages(ctx context.Context, chatID int64, limit int) ([]Message, error) {
-	var messages []Message
+	page, err := c.GetHistory(ctx, chatID, HistoryQuery{Limit: limit})
+	if err != nil {
+		return nil, err
+	}
+	return page.Messages, nil
+}
+
+// GetHistory отримує сторінку історії чату відповідно до query
+func (c *Client) GetHistory(ctx context.Context, chatID int64, query HistoryQuery) (*HistoryPage, error) {
+	page := &HistoryPage{}
 
-	err := c.Client.Run(ctx, func(ctx context.Context) error {
-		// Отримуємо API клієнт всередині Run callback
+	err := c.run(ctx, func(ctx context.Context) error {
+		// Отримуємо API клієнт всередині з'єднання
 		api := c.Client.API()
 
 		// Створюємо InputPeer для чату
@@ -36,37 +105,73 @@ func (c *Client) GetMessages(ctx context.Context, chatID int64, limit int) ([]Me
 			return fmt.Errorf("get input peer error: %w", err)
 		}
 
+		request := &tg.MessagesGetHistoryRequest{
+			Peer:       peer,
+			Limit:      query.Limit,
+			OffsetDate: query.OffsetDate,
+		}
+		switch {
+		case query.BeforeID > 0:
+			request.OffsetID = query.BeforeID
+		case query.AfterID > 0:
+			// Зсув на limit+1 вперед від AfterID: вікно закінчується одразу
+			// перед самим AfterID, тож сторінка повна; MinID відсікає старіші
+			request.OffsetID = query.AfterID
+			request.AddOffset = -query.Limit - 1
+			request.MinID = query.AfterID
+		case query.AroundID > 0:
+			// Половина сторінки новіша за AroundID, решта - він сам і старіші
+			request.OffsetID = query.AroundID
+			request.AddOffset = -query.Limit / 2
+		}
+
 		// Отримуємо історію повідомлень
-		result, err := api.MessagesGetHistory(ctx, &tg.MessagesGetHistoryRequest{
-			Peer:  peer,
-			Limit: limit,
-		})
+		result, err := api.MessagesGetHistory(ctx, request)
 		if err != nil {
 			return fmt.Errorf("get history error: %w", err)
 		}
 
 		var messagesSlice *tg.MessagesMessages
+		// Позиція першого повідомлення у вибірці та її розмір;
+		// offset < 0 - Telegram позицію не повідомив
+		count, offset := 0, -1
+		complete := false
 		switch m := result.(type) {
 		case *tg.MessagesMessages:
+			// Telegram віддав усе, що підходить під запит
 			messagesSlice = m
+			count = len(m.Messages)
+			complete = true
 		case *tg.MessagesMessagesSlice:
 			messagesSlice = &tg.MessagesMessages{
 				Messages: m.Messages,
 				Chats:    m.Chats,
 				Users:    m.Users,
 			}
+			count = m.Count
+			if v, ok := m.GetOffsetIDOffset(); ok {
+				offset = v
+			}
 		case *tg.MessagesChannelMessages:
 			messagesSlice = &tg.MessagesMessages{
 				Messages: m.Messages,
 				Chats:    m.Chats,
 				Users:    m.Users,
 			}
+			count = m.Count
+			if v, ok := m.GetOffsetIDOffset(); ok {
+				offset = v
+			}
 		case *tg.MessagesMessagesNotModified:
 			return nil
 		default:
 			return fmt.Errorf("unexpected messages type: %T", result)
 		}
 
+		page.HasMoreOlder, page.HasMoreNewer = historyHasMore(query, len(messagesSlice.Messages), count, offset, complete)
+
+		c.peers.Apply(messagesSlice.Users, messagesSlice.Chats)
+
 		// Створюємо мапу користувачів
 		users := make(map[int64]*tg.User)
 		for _, u := range messagesSlice.Users {
@@ -76,109 +181,324 @@ func (c *Client) GetMessages(ctx context.Context, chatID int64, limit int) ([]Me
 		}
 
 		// Обробляємо повідомлення
+		raw := make(map[int]*tg.Message)
 		for _, m := range messagesSlice.Messages {
 			msg, ok := m.(*tg.Message)
 			if !ok {
 				continue
 			}
+			raw[msg.ID] = msg
 
-			senderName := "Unknown"
-			if msg.Out {
-				senderName = "You"
-			} else {
-				// Для вхідних повідомлень визначаємо відправника
-				if msg.FromID != nil {
-					switch fromPeer := msg.FromID.(type) {
-					case *tg.PeerUser:
-						if user, exists := users[fromPeer.UserID]; exists {
-							senderName = GetUserName(user)
-						}
-					case *tg.PeerChannel:
-						senderName = "Channel"
-					case *tg.PeerChat:
-						senderName = "Chat"
-					}
-				} else {
-					// Якщо FromID == nil, беремо з PeerID (для особистих чатів)
-					if peerUser, ok := msg.PeerID.(*tg.PeerUser); ok {
-						if user, exists := users[peerUser.UserID]; exists {
-							senderName = GetUserName(user)
-						}
-					}
-				}
+			if message, ok := c.convertMessage(msg, users); ok {
+				page.Messages = append(page.Messages, message)
 			}
+		}
 
-			// Визначаємо текст повідомлення і тип медіа
-			messageText := msg.Message
-			hasPhoto := false
-			var photoID int64
-
-			if msg.Media != nil {
-				switch media := msg.Media.(type) {
-				case *tg.MessageMediaPhoto:
-					if photo, ok := media.Photo.(*tg.Photo); ok {
-						hasPhoto = true
-						photoID = photo.ID
-						if messageText == "" {
-							messageText = "📷 Фото"
-						}
-					}
-				case *tg.MessageMediaDocument:
-					if messageText == "" {
-						messageText = "📎 Файл"
-					}
-				case *tg.MessageMediaGeo:
-					if messageText == "" {
-						messageText = "📍 Локація"
-					}
-				case *tg.MessageMediaContact:
-					if messageText == "" {
-						messageText = "👤 Контакт"
-					}
-				case *tg.MessageMediaVenue:
-					if messageText == "" {
-						messageText = "📍 Місце"
-					}
-				case *tg.MessageMediaWebPage:
-					if messageText == "" {
-						messageText = "🔗 Посилання"
-					}
-				default:
-					if messageText == "" {
-						messageText = "💬 Медіа"
-					}
+		c.fillReplies(ctx, peer, page.Messages, raw, users)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return page, nil
+}
+
+// historyHasMore визначає, чи є повідомлення поза сторінкою в кожному
+// напрямку. got - скільки повідомлень повернув Telegram (до фільтрації);
+// complete - Telegram віддав не частину (messages.messages), а всю історію.
+func historyHasMore(query HistoryQuery, got, count, offset int, complete bool) (older, newer bool) {
+	if complete {
+		// Старіших за отримані немає (крім самого AfterID і старіших за нього);
+		// новіші - лише якщо сторінку взято зі зсувом
+		return query.AfterID > 0, query.BeforeID > 0 || query.AroundID > 0 || query.OffsetDate > 0
+	}
+	if query.AfterID > 0 {
+		// MinID звужує вибірку, тож позиції Telegram тут неточні:
+		// старіші є завжди (саме AfterID), новіші - якщо сторінка повна
+		return true, got >= query.Limit
+	}
+	if offset >= 0 {
+		return count > offset+got, offset > 0
+	}
+
+	// Позиція невідома - оцінюємо за розміром сторінки
+	older = got >= query.Limit
+	newer = query.BeforeID > 0 || query.AroundID > 0 || query.OffsetDate > 0
+	return older, newer
+}
+
+// convertMessage перетворює повідомлення Telegram на формат шлюзу.
+// users - користувачі з тієї ж відповіді; решта шукаються в PeerStore.
+func (c *Client) convertMessage(msg *tg.Message, users map[int64]*tg.User) (Message, bool) {
+	senderName := c.senderName(msg, users)
+
+	// Визначаємо текст повідомлення і тип медіа
+	messageText := msg.Message
+	hasPhoto := false
+	var photoID int64
+
+	if msg.Media != nil {
+		switch media := msg.Media.(type) {
+		case *tg.MessageMediaPhoto:
+			if photo, ok := media.Photo.(*tg.Photo); ok {
+				hasPhoto = true
+				photoID = photo.ID
+				if messageText == "" {
+					messageText = "📷 Фото"
 				}
-			} else if messageText == "" {
-				// Пропускаємо порожні повідомлення без медіа
-				continue
 			}
+		case *tg.MessageMediaDocument:
+			if messageText == "" {
+				messageText = "📎 Файл"
+			}
+		case *tg.MessageMediaGeo:
+			if messageText == "" {
+				messageText = "📍 Локація"
+			}
+		case *tg.MessageMediaContact:
+			if messageText == "" {
+				messageText = "👤 Контакт"
+			}
+		case *tg.MessageMediaVenue:
+			if messageText == "" {
+				messageText = "📍 Місце"
+			}
+		case *tg.MessageMediaWebPage:
+			if messageText == "" {
+				messageText = "🔗 Посилання"
+			}
+		default:
+			if messageText == "" {
+				messageText = "💬 Медіа"
+			}
+		}
+	} else if messageText == "" {
+		// Пропускаємо порожні повідомлення без медіа
+		return Message{}, false
+	}
+
+	message := Message{
+		ID:        msg.ID,
+		ChatID:    strconv.FormatInt(MarkedPeerID(msg.PeerID), 10),
+		ChatName:  "",
+		Text:      messageText,
+		Sender:    senderName,
+		Timestamp: time.Unix(int64(msg.Date), 0),
+		IsRead:    !msg.Out || msg.Out && msg.ID <= 0,
+		Out:       msg.Out,
+		HasPhoto:  hasPhoto,
+		PhotoID:   photoID,
+		Links:     EntityLinks(msg.Message, msg.Entities),
+		raw:       msg.Message,
+		entities:  msg.Entities,
+	}
+
+	if header, ok := msg.ReplyTo.(*tg.MessageReplyHeader); ok {
+		if id, ok := header.GetReplyToMsgID(); ok {
+			message.ReplyTo = &ReplyInfo{MessageID: id}
+			if peer, ok := header.GetReplyToPeerID(); ok && !SameChat(MarkedPeerID(peer), MarkedPeerID(msg.PeerID)) {
+				message.ReplyTo.ChatID = strconv.FormatInt(MarkedPeerID(peer), 10)
+			}
+			// Цитата - саме те, що користувач виділив, тож вона важливіша за початок тексту
+			if quote, ok := header.GetQuoteText(); ok {
+				message.ReplyTo.Text = snippet(quote)
+			}
+		}
+	}
+
+	if fwd, ok := msg.GetFwdFrom(); ok {
+		forward := &ForwardInfo{Date: time.Unix(int64(fwd.Date), 0)}
+		if name, ok := fwd.GetFromName(); ok {
+			// Користувач приховав профіль у пересланих
+			forward.From = name
+		} else if from, ok := fwd.GetFromID(); ok {
+			forward.From = c.peerName(from, users)
+		}
+		if forward.From == "" {
+			forward.From = "Unknown"
+		}
+		message.Forward = forward
+	}
+
+	if editDate, ok := msg.GetEditDate(); ok && !msg.EditHide {
+		editedAt := time.Unix(int64(editDate), 0)
+		message.Edited = true
+		message.EditedAt = &editedAt
+	}
+
+	if botID, ok := msg.GetViaBotID(); ok {
+		if bot, exists := c.lookupUser(users, botID); exists {
+			message.ViaBot = GetUserName(bot)
+		}
+	}
 
-			messages = append(messages, Message{
-				ID:        msg.ID,
-				ChatID:    strconv.FormatInt(chatID, 10),
-				ChatName:  "",
-				Text:      messageText,
-				Sender:    senderName,
-				Timestamp: time.Unix(int64(msg.Date), 0),
-				IsRead:    !msg.Out || msg.Out && msg.ID <= 0,
-				Out:       msg.Out,
-				HasPhoto:  hasPhoto,
-				PhotoID:   photoID,
+	return message, true
+}
+
+// fillReplies додає текст і відправника до відповідей сторінки історії.
+// Повідомлення, яких немає на сторінці, довантажуються одним запитом;
+// помилка лише залишає відповіді без тексту.
+func (c *Client) fillReplies(ctx context.Context, peer tg.InputPeerClass, messages []Message, raw map[int]*tg.Message, users map[int64]*tg.User) {
+	var missing []tg.InputMessageClass
+	requested := make(map[int]bool)
+	for _, m := range messages {
+		if m.ReplyTo == nil || m.ReplyTo.ChatID != "" {
+			continue
+		}
+		id := m.ReplyTo.MessageID
+		if _, ok := raw[id]; !ok && !requested[id] {
+			requested[id] = true
+			missing = append(missing, &tg.InputMessageID{ID: id})
+		}
+	}
+
+	if len(missing) > 0 {
+		api := c.Client.API()
+		var result tg.MessagesMessagesClass
+		var err error
+		if channel, ok := InputChannel(peer); ok {
+			result, err = api.ChannelsGetMessages(ctx, &tg.ChannelsGetMessagesRequest{
+				Channel: channel,
+				ID:      missing,
 			})
+		} else {
+			result, err = api.MessagesGetMessages(ctx, missing)
+		}
+		if err != nil {
+			log.Printf("Messages: Failed to load replied messages: %v", err)
+		} else if modified, ok := result.AsModified(); ok {
+			c.peers.Apply(modified.GetUsers(), modified.GetChats())
+			for _, u := range modified.GetUsers() {
+				if user, ok := u.(*tg.User); ok {
+					users[user.ID] = user
+				}
+			}
+			for _, m := range modified.GetMessages() {
+				if msg, ok := m.(*tg.Message); ok {
+					raw[msg.ID] = msg
+				}
+			}
 		}
+	}
 
-		return nil
-	})
+	for i := range messages {
+		reply := messages[i].ReplyTo
+		if reply == nil || reply.ChatID != "" {
+			continue
+		}
+		msg, ok := raw[reply.MessageID]
+		if !ok {
+			continue
+		}
+		replied, ok := c.convertMessage(msg, users)
+		if !ok {
+			continue
+		}
+		reply.Sender = replied.Sender
+		if reply.Text == "" {
+			reply.Text = snippet(replied.Text)
+		}
+	}
+}
+
+// senderName визначає ім'я відправника повідомлення
+func (c *Client) senderName(msg *tg.Message, users map[int64]*tg.User) string {
+	if msg.Out {
+		return "You"
+	}
+
+	// Для вхідних повідомлень визначаємо відправника
+	if msg.FromID != nil {
+		switch fromPeer := msg.FromID.(type) {
+		case *tg.PeerUser:
+			if user, exists := c.lookupUser(users, fromPeer.UserID); exists {
+				return GetUserName(user)
+			}
+		case *tg.PeerChannel:
+			return "Channel"
+		case *tg.PeerChat:
+			return "Chat"
+		}
+		return "Unknown"
+	}
+
+	// Якщо FromID == nil, беремо з PeerID (для особистих чатів)
+	if peerUser, ok := msg.PeerID.(*tg.PeerUser); ok {
+		if user, exists := c.lookupUser(users, peerUser.UserID); exists {
+			return GetUserName(user)
+		}
+	}
+	return "Unknown"
+}
+
+// peerName повертає ім'я користувача або назву чату ("" - невідомий)
+func (c *Client) peerName(peer tg.PeerClass, users map[int64]*tg.User) string {
+	if p, ok := peer.(*tg.PeerUser); ok {
+		if user, exists := c.lookupUser(users, p.UserID); exists {
+			return GetUserName(user)
+		}
+		return ""
+	}
+	title, _ := c.peers.Title(MarkedPeerID(peer))
+	return title
+}
+
+// snippet обрізає текст до replySnippetLength символів в один рядок
+func snippet(text string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	runes := []rune(text)
+	if len(runes) <= replySnippetLength {
+		return text
+	}
+	return string(runes[:replySnippetLength]) + "…"
+}
+
+// lookupUser шукає користувача у відповіді, а потім у PeerStore
+func (c *Client) lookupUser(users map[int64]*tg.User, id int64) (*tg.User, bool) {
+	if user, exists := users[id]; exists {
+		return user, true
+	}
+	return c.peers.User(id)
+}
+
+// OutgoingMessage - текстове повідомлення для відправки
+type OutgoingMessage struct {
+	Text string
+	// Форматування тексту (див. PrepareText)
+	Entities []tg.MessageEntityClass
+	// ID повідомлення того ж чату, на яке це відповідь (0 - без відповіді)
+	ReplyTo int
+	// random_id від клієнта (див. IdempotencyRandomID): повтори з тим самим
+	// значенням не надсилають повідомлення вдруге. 0 - згенерувати новий.
+	RandomID int64
+}
 
-	return messages, err
+// SendMessage відправляє повідомлення і повертає його ID, дату і остаточний текст
+func (c *Client) SendMessage(ctx context.Context, chatID int64, out OutgoingMessage) (*SentMessage, error) {
+	// Журнал повторів порівнює позначені ID: у режимі сумісності клієнт
+	// може передати той самий чат то сирим, то позначеним ID
+	peer, err := c.GetInputPeer(ctx, chatID)
+	if err != nil {
+		return nil, fmt.Errorf("get input peer error: %w", err)
+	}
+	if id, ok := InputPeerID(peer, c.selfID()); ok {
+		chatID = id
+	}
+
+	if out.RandomID == 0 {
+		return c.sendMessage(ctx, chatID, out, newRandomID())
+	}
+	return c.sent.do(ctx, chatID, out.RandomID, func() (*SentMessage, error) {
+		return c.sendMessage(ctx, chatID, out, out.RandomID)
+	})
 }
 
-// SendMessage відправляє повідомлення
-func (c *Client) SendMessage(ctx context.Context, chatID int64, text string) (int, error) {
-	var messageID int
+func (c *Client) sendMessage(ctx context.Context, chatID int64, out OutgoingMessage, randomID int64) (*SentMessage, error) {
+	var sent *SentMessage
 
-	err := c.Client.Run(ctx, func(ctx context.Context) error {
-		// Отримуємо API клієнт всередині Run callback
+	err := c.run(ctx, func(ctx context.Context) error {
+		// Отримуємо API клієнт всередині з'єднання
 		api := c.Client.API()
 
 		// Створюємо InputPeer для чату
@@ -187,41 +507,42 @@ func (c *Client) SendMessage(ctx context.Context, chatID int64, text string) (in
 			return fmt.Errorf("get input peer error: %w", err)
 		}
 
-		// Генеруємо випадковий ID для повідомлення
-		randomID := time.Now().UnixNano()
-
 		// Відправляємо повідомлення
-		updates, err := api.MessagesSendMessage(ctx, &tg.MessagesSendMessageRequest{
+		request := &tg.MessagesSendMessageRequest{
 			Peer:     peer,
-			Message:  text,
+			Message:  out.Text,
 			RandomID: randomID,
-		})
+		}
+		if len(out.Entities) > 0 {
+			request.SetEntities(out.Entities)
+		}
+		if out.ReplyTo > 0 {
+			request.SetReplyTo(&tg.InputReplyToMessage{ReplyToMsgID: out.ReplyTo})
+		}
+		updates, err := api.MessagesSendMessage(ctx, request)
 		if err != nil {
 			return fmt.Errorf("send message error: %w", err)
 		}
 
-		// Отримуємо ID відправленого повідомлення
-		switch u := updates.(type) {
-		case *tg.Updates:
-			if len(u.Updates) > 0 {
-				if msgUpdate, ok := u.Updates[0].(*tg.UpdateMessageID); ok {
-					messageID = msgUpdate.ID
-				}
-			}
-		case *tg.UpdateShortSentMessage:
-			messageID = u.ID
-		}
+		sent = sentFromUpdates(updates, chatID, randomID, out.Text)
 
+		c.handleUpdates(ctx, "SendMessage", updates)
 		return nil
 	})
-
-	return messageID, err
+	if tgerr.Is(err, "RANDOM_ID_DUPLICATE") {
+		// Перша спроба дійшла до Telegram, але її результат втрачено
+		return c.findSent(ctx, chatID, randomID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return sent, nil
 }
 
 // MarkAsRead позначає повідомлення як прочитані
 func (c *Client) MarkAsRead(ctx context.Context, chatID int64, maxID int) error {
-	return c.Client.Run(ctx, func(ctx context.Context) error {
-		// Отримуємо API клієнт всередині Run callback
+	return c.run(ctx, func(ctx context.Context) error {
+		// Отримуємо API клієнт всередині з'єднання
 		api := c.Client.API()
 
 		// Створюємо InputPeer для чату
@@ -230,15 +551,11 @@ func (c *Client) MarkAsRead(ctx context.Context, chatID int64, maxID int) error
 			return fmt.Errorf("get input peer error: %w", err)
 		}
 
-		// Перевіряємо тип peer'а
-		if _, ok := peer.(*tg.InputPeerChannel); ok {
-			// Для каналів використовуємо інший метод
+		// Для каналів використовуємо інший метод
+		if channel, ok := InputChannel(peer); ok {
 			_, err = api.ChannelsReadHistory(ctx, &tg.ChannelsReadHistoryRequest{
-				Channel: &tg.InputChannel{
-					ChannelID:  chatID,
-					AccessHash: 0, // TODO: отримувати access hash
-				},
-				MaxID: maxID,
+				Channel: channel,
+				MaxID:   maxID,
 			})
 			return err
 		}
@@ -251,12 +568,3 @@ func (c *Client) MarkAsRead(ctx context.Context, chatID int64, maxID int) error
 		return err
 	})
 }
-
-// GetInputPeer створює InputPeer для чату
-func (c *Client) GetInputPeer(ctx context.Context, chatID int64) (tg.InputPeerClass, error) {
-	// Для простоти спочатку пробуємо InputPeerUser
-	// В production потрібно зберігати тип і access_hash для кожного чату
-	return &tg.InputPeerUser{
-		UserID: chatID,
-	}, nil
-}
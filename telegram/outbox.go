@@ -0,0 +1,517 @@
+package telegram
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"telegram-gateway/storage"
+	"time"
+
+	"github.com/gotd/td/pool"
+	"github.com/gotd/td/rpc"
+	"github.com/gotd/td/tgerr"
+)
+
+// Стани повідомлення в черзі відправки
+const (
+	OutboxPending = "pending"
+	OutboxSent    = "sent"
+	OutboxFailed  = "failed"
+)
+
+// Коди помилок черги, яких немає серед MessageErr*
+const (
+	OutboxErrExpired       = "expired"
+	OutboxErrNotAuthorized = "not_authorized"
+	OutboxErrInvalidMarkup = "invalid_markup"
+)
+
+const (
+	// Як часто перевіряється черга
+	outboxInterval = 5 * time.Second
+	// Затримка першого повтору; далі подвоюється до outboxMaxBackoff
+	outboxBackoff    = 5 * time.Second
+	outboxMaxBackoff = 10 * time.Minute
+	// Скільки повідомлення чекає на відправку, перш ніж стати failed
+	outboxMaxAge = 24 * time.Hour
+	// Скільки пам'ятаються надіслані й невдалі повідомлення
+	outboxKeep = 24 * time.Hour
+	// Скільки часу дається на одну спробу
+	outboxSendTimeout = 30 * time.Second
+)
+
+// ErrOutboxNotFound повертається для невідомого повідомлення черги
+var ErrOutboxNotFound = errors.New("outbox message not found")
+
+// OutboxEntry - повідомлення в черзі відправки акаунта
+type OutboxEntry struct {
+	ID          string     `json:"id"`
+	ChatID      string     `json:"chat_id"`
+	Text        string     `json:"text"`
+	ParseMode   ParseMode  `json:"parse_mode,omitempty"`
+	ReplyTo     int        `json:"reply_to,omitempty"`
+	Status      string     `json:"status"`
+	MessageID   int        `json:"message_id,omitempty"`
+	Attempts    int        `json:"attempts"`
+	NextAttempt *time.Time `json:"next_attempt,omitempty"`
+	Code        string     `json:"code,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+
+	// random_id повідомлення: повторні спроби не створюють дублікатів
+	RandomID int64 `json:"random_id,string"`
+}
+
+// OutboxKey повертає ключ черги відправки для сесії sessionKey
+func OutboxKey(sessionKey string) string {
+	return "outbox:" + sessionKey
+}
+
+// Enqueue ставить повідомлення в чергу акаунта key і повертає його запис.
+// Повтор з тим самим RandomID повертає вже наявний запис.
+func (m *Manager) Enqueue(ctx context.Context, key string, chatID int64, text string, mode ParseMode, replyTo int, randomID int64) (*OutboxEntry, error) {
+	if randomID == 0 {
+		randomID = newRandomID()
+	}
+
+	m.outboxMu.Lock()
+	defer m.outboxMu.Unlock()
+	if m.outboxPurging[key] > 0 {
+		// Акаунт саме виходить
+		return nil, &MessageError{Code: OutboxErrNotAuthorized, Err: ErrNotAuthorized}
+	}
+
+	entries, err := m.loadOutbox(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.RandomID == randomID {
+			// Той самий чат може прийти то сирим, то позначеним ID
+			queued, _ := strconv.ParseInt(entry.ChatID, 10, 64)
+			if !SameChat(queued, chatID) && !SameChat(chatID, queued) {
+				return nil, &MessageError{
+					Code: MessageErrKeyConflict,
+					Err:  fmt.Errorf("random_id %d was already used in chat %s", randomID, entry.ChatID),
+				}
+			}
+			return &entry, nil
+		}
+	}
+
+	now := time.Now()
+	entry := OutboxEntry{
+		ID:          newOutboxID(),
+		ChatID:      formatChatID(chatID),
+		Text:        text,
+		ParseMode:   mode,
+		ReplyTo:     replyTo,
+		Status:      OutboxPending,
+		NextAttempt: &now,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+		RandomID:    randomID,
+	}
+	entries = append(entries, entry)
+	if err := m.saveOutbox(ctx, key, entries); err != nil {
+		return nil, err
+	}
+	m.outboxKeys[key] = true
+
+	m.emitOutbox(key, entry)
+	return &entry, nil
+}
+
+// OutboxEntries повертає чергу акаунта від старих до нових
+func (m *Manager) OutboxEntries(ctx context.Context, key string) ([]OutboxEntry, error) {
+	m.outboxMu.Lock()
+	defer m.outboxMu.Unlock()
+	return m.loadOutbox(ctx, key)
+}
+
+// OutboxEntry повертає повідомлення черги за його ID
+func (m *Manager) OutboxEntry(ctx context.Context, key, id string) (*OutboxEntry, error) {
+	entries, err := m.OutboxEntries(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.ID == id {
+			return &entry, nil
+		}
+	}
+	return nil, ErrOutboxNotFound
+}
+
+// Deliver одразу пробує надіслати повідомлення черги id, за потреби
+// підключаючи акаунт. Повертає оновлений запис; якщо спроба не вдалася
+// (зокрема Telegram недоступний), повідомлення лишається в черзі і буде
+// надіслане пізніше.
+func (m *Manager) Deliver(ctx context.Context, key, id string) (*OutboxEntry, error) {
+	entry, ok, err := m.claimOutbox(ctx, key, id, false)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		// Уже надсилається з черги або надіслане
+		return m.OutboxEntry(ctx, key, id)
+	}
+
+	client, err := m.Acquire(ctx, key)
+	if err != nil {
+		log.Printf("Outbox: Failed to connect %s: %v", key, err)
+		return m.postponeOutbox(ctx, key, entry, err)
+	}
+	return m.attemptOutbox(ctx, key, entry, client)
+}
+
+// RunOutbox надсилає повідомлення з черг акаунтів, повторюючи невдалі
+// спроби з наростаючою затримкою і з урахуванням FLOOD_WAIT
+func (m *Manager) RunOutbox(ctx context.Context) {
+	// Черги, що лишилися з попереднього запуску
+	if entries, err := m.store.List(ctx); err != nil {
+		log.Printf("Outbox: Failed to list queues: %v", err)
+	} else {
+		m.outboxMu.Lock()
+		for _, entry := range entries {
+			if key, ok := strings.CutPrefix(entry.Key, "outbox:"); ok {
+				m.outboxKeys[key] = true
+			}
+		}
+		m.outboxMu.Unlock()
+	}
+
+	ticker := time.NewTicker(outboxInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.processOutbox(ctx)
+		}
+	}
+}
+
+// processOutbox надсилає всі повідомлення, час спроби яких настав
+func (m *Manager) processOutbox(ctx context.Context) {
+	m.outboxMu.Lock()
+	keys := make([]string, 0, len(m.outboxKeys))
+	for key := range m.outboxKeys {
+		keys = append(keys, key)
+	}
+	m.outboxMu.Unlock()
+
+	for _, key := range keys {
+		due, pending, err := m.dueOutbox(ctx, key)
+		if err != nil {
+			log.Printf("Outbox: Failed to load queue %s: %v", key, err)
+			continue
+		}
+		if !pending {
+			m.outboxMu.Lock()
+			delete(m.outboxKeys, key)
+			m.outboxMu.Unlock()
+			continue
+		}
+		if len(due) == 0 {
+			continue
+		}
+
+		connectCtx, cancel := context.WithTimeout(ctx, outboxSendTimeout)
+		client, err := m.Acquire(connectCtx, key)
+		cancel()
+		if err != nil {
+			log.Printf("Outbox: Failed to connect %s: %v", key, err)
+			for _, id := range due {
+				if entry, ok, _ := m.claimOutbox(ctx, key, id, true); ok {
+					m.postponeOutbox(ctx, key, entry, err)
+				}
+			}
+			continue
+		}
+
+		for _, id := range due {
+			entry, ok, err := m.claimOutbox(ctx, key, id, true)
+			if err != nil || !ok {
+				continue
+			}
+			sendCtx, cancel := context.WithTimeout(ctx, outboxSendTimeout)
+			if _, err := m.attemptOutbox(sendCtx, key, entry, client); err != nil {
+				log.Printf("Outbox: Failed to update %s in %s: %v", entry.ID, key, err)
+			}
+			cancel()
+		}
+	}
+}
+
+// dueOutbox повертає ID повідомлень, час спроби яких настав, і чи є
+// в черзі повідомлення, що ще чекають на відправку
+func (m *Manager) dueOutbox(ctx context.Context, key string) ([]string, bool, error) {
+	m.outboxMu.Lock()
+	defer m.outboxMu.Unlock()
+
+	entries, err := m.loadOutbox(ctx, key)
+	if err != nil {
+		return nil, false, err
+	}
+
+	now := time.Now()
+	var due []string
+	pending := false
+	for _, entry := range entries {
+		if entry.Status != OutboxPending {
+			continue
+		}
+		pending = true
+		if m.outboxBusy[entry.ID] || entry.NextAttempt != nil && entry.NextAttempt.After(now) {
+			continue
+		}
+		due = append(due, entry.ID)
+	}
+	return due, pending, nil
+}
+
+// claimOutbox позначає повідомлення як таке, що надсилається зараз.
+// due=true - лише якщо час спроби вже настав.
+func (m *Manager) claimOutbox(ctx context.Context, key, id string, due bool) (OutboxEntry, bool, error) {
+	m.outboxMu.Lock()
+	defer m.outboxMu.Unlock()
+
+	entries, err := m.loadOutbox(ctx, key)
+	if err != nil {
+		return OutboxEntry{}, false, err
+	}
+	for _, entry := range entries {
+		if entry.ID != id {
+			continue
+		}
+		if entry.Status != OutboxPending || m.outboxBusy[id] {
+			return entry, false, nil
+		}
+		if due && entry.NextAttempt != nil && entry.NextAttempt.After(time.Now()) {
+			return entry, false, nil
+		}
+		m.outboxBusy[id] = true
+		return entry, true, nil
+	}
+	return OutboxEntry{}, false, ErrOutboxNotFound
+}
+
+// attemptOutbox робить одну спробу надіслати повідомлення і зберігає результат
+func (m *Manager) attemptOutbox(ctx context.Context, key string, entry OutboxEntry, client *Client) (*OutboxEntry, error) {
+	chatID, err := strconv.ParseInt(entry.ChatID, 10, 64)
+	if err != nil {
+		return m.finishOutbox(ctx, key, entry, OutboxFailed, 0, MessageErrFailed, err, time.Time{}, false)
+	}
+
+	text, entities, err := client.PrepareText(entry.Text, entry.ParseMode)
+	var parseErr *ParseError
+	if errors.As(err, &parseErr) {
+		return m.finishOutbox(ctx, key, entry, OutboxFailed, 0, OutboxErrInvalidMarkup, err, time.Time{}, false)
+	}
+	if err == nil {
+		var sent *SentMessage
+		sent, err = client.SendMessage(ctx, chatID, OutgoingMessage{
+			Text:     text,
+			Entities: entities,
+			ReplyTo:  entry.ReplyTo,
+			RandomID: entry.RandomID,
+		})
+		if err == nil {
+			return m.finishOutbox(ctx, key, entry, OutboxSent, sent.ID, "", nil, time.Time{}, true)
+		}
+	}
+
+	messageErr := AsMessageError(err)
+	if !retryable(err, messageErr) {
+		return m.finishOutbox(ctx, key, entry, OutboxFailed, 0, messageErr.Code, err, time.Time{}, true)
+	}
+	return m.retryOutbox(ctx, key, entry, messageErr.Code, err, messageErr.Until, true)
+}
+
+// retryOutbox відкладає наступну спробу: до кінця FLOOD_WAIT (until)
+// або з подвоєнням затримки після кожної невдачі. attempted - як у finishOutbox.
+func (m *Manager) retryOutbox(ctx context.Context, key string, entry OutboxEntry, code string, cause error, until time.Time, attempted bool) (*OutboxEntry, error) {
+	if time.Since(entry.CreatedAt) > outboxMaxAge {
+		return m.finishOutbox(ctx, key, entry, OutboxFailed, 0, OutboxErrExpired, cause, time.Time{}, attempted)
+	}
+
+	next := until
+	if next.IsZero() {
+		delay := outboxBackoff
+		for i := 0; i < entry.Attempts && delay < outboxMaxBackoff; i++ {
+			delay *= 2
+		}
+		if delay > outboxMaxBackoff {
+			delay = outboxMaxBackoff
+		}
+		next = time.Now().Add(delay)
+	}
+	return m.finishOutbox(ctx, key, entry, OutboxPending, 0, code, cause, next, attempted)
+}
+
+// postponeOutbox відкладає повідомлення, для якого не вдалося підключити
+// акаунт. Спроби доставки не було, тож Attempts не зростає; якщо сесію
+// відкликано, повідомлення стає failed.
+func (m *Manager) postponeOutbox(ctx context.Context, key string, entry OutboxEntry, err error) (*OutboxEntry, error) {
+	if errors.Is(err, ErrNotAuthorized) {
+		return m.finishOutbox(ctx, key, entry, OutboxFailed, 0, OutboxErrNotAuthorized, err, time.Time{}, false)
+	}
+	return m.retryOutbox(ctx, key, entry, MessageErrFailed, err, time.Time{}, false)
+}
+
+// finishOutbox зберігає результат спроби, знімає позначку claimOutbox
+// і повідомляє клієнтів подією outbox_status. attempted - чи була спроба
+// доставки (лише тоді зростає Attempts).
+func (m *Manager) finishOutbox(ctx context.Context, key string, entry OutboxEntry, status string, messageID int, code string, cause error, next time.Time, attempted bool) (*OutboxEntry, error) {
+	// Результат треба зберегти, навіть якщо час запиту на спробу вже вийшов
+	ctx = context.WithoutCancel(ctx)
+
+	m.outboxMu.Lock()
+	defer m.outboxMu.Unlock()
+	delete(m.outboxBusy, entry.ID)
+	if m.outboxPurging[key] > 0 {
+		// Черга видаляється разом з даними акаунта
+		return nil, ErrOutboxNotFound
+	}
+
+	entries, err := m.loadOutbox(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	for i := range entries {
+		if entries[i].ID != entry.ID {
+			continue
+		}
+		e := &entries[i]
+		e.Status = status
+		e.MessageID = messageID
+		if attempted {
+			e.Attempts++
+		}
+		e.Code = code
+		e.Error = ""
+		if cause != nil {
+			e.Error = cause.Error()
+		}
+		e.NextAttempt = nil
+		if status == OutboxPending {
+			e.NextAttempt = &next
+		}
+		e.UpdatedAt = now
+
+		updated := *e
+		if err := m.saveOutbox(ctx, key, entries); err != nil {
+			return nil, err
+		}
+		if status != OutboxPending || updated.Attempts <= 1 {
+			log.Printf("Outbox: Message %s in %s is %s (attempts: %d, code: %s)", e.ID, key, status, e.Attempts, code)
+		}
+		m.emitOutbox(key, updated)
+		return &updated, nil
+	}
+	// Запис видалено (наприклад, вихід з акаунта)
+	return nil, ErrOutboxNotFound
+}
+
+// retryable визначає, чи варто повторити спробу після помилки:
+// FLOOD_WAIT, внутрішні помилки Telegram, збої мережі і закрите
+// з'єднання - так, відмова Telegram щодо самого повідомлення чи чату
+// і локальні помилки - ні
+func retryable(err error, messageErr *MessageError) bool {
+	switch messageErr.Code {
+	case MessageErrFloodWait:
+		return true
+	case MessageErrFailed:
+	default:
+		return false
+	}
+	if rpcErr, ok := tgerr.As(err); ok {
+		return rpcErr.Code >= 500
+	}
+
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, ErrConnectionClosed) ||
+		errors.Is(err, rpc.ErrEngineClosed) ||
+		errors.Is(err, pool.ErrConnDead) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.As(err, &netErr)
+}
+
+// emitOutbox додає в журнал подій акаунта зміну стану повідомлення черги
+func (m *Manager) emitOutbox(key string, entry OutboxEntry) {
+	m.eventLog(key).Append(Event{
+		Type:   EventOutbox,
+		ChatID: entry.ChatID,
+		Outbox: &entry,
+	})
+}
+
+// loadOutbox читає чергу акаунта; викликається під m.outboxMu
+func (m *Manager) loadOutbox(ctx context.Context, key string) ([]OutboxEntry, error) {
+	data, err := m.store.Load(ctx, OutboxKey(key))
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load outbox: %w", err)
+	}
+
+	var entries []OutboxEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("failed to decode outbox: %w", err)
+	}
+	return entries, nil
+}
+
+// saveOutbox зберігає чергу, забуваючи давно завершені повідомлення;
+// викликається під m.outboxMu
+func (m *Manager) saveOutbox(ctx context.Context, key string, entries []OutboxEntry) error {
+	deadline := time.Now().Add(-outboxKeep)
+	kept := entries[:0]
+	for _, entry := range entries {
+		if entry.Status != OutboxPending && entry.UpdatedAt.Before(deadline) {
+			continue
+		}
+		kept = append(kept, entry)
+	}
+	sort.SliceStable(kept, func(i, j int) bool {
+		return kept[i].CreatedAt.Before(kept[j].CreatedAt)
+	})
+
+	if len(kept) == 0 {
+		return m.store.Delete(ctx, OutboxKey(key))
+	}
+	data, err := json.Marshal(kept)
+	if err != nil {
+		return err
+	}
+	if err := m.store.Save(ctx, OutboxKey(key), data); err != nil {
+		return fmt.Errorf("failed to save outbox: %w", err)
+	}
+	return nil
+}
+
+func newOutboxID() string {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(buf)
+}
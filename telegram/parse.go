@@ -0,0 +1,471 @@
+package telegram
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/gotd/td/tg"
+)
+
+// ParseMode - синтаксис форматування тексту, що надсилається
+type ParseMode string
+
+const (
+	ParseModeNone     ParseMode = ""
+	ParseModeMarkdown ParseMode = "markdown"
+	ParseModeHTML     ParseMode = "html"
+)
+
+// ParseModeByName розбирає назву синтаксису; порожня назва - без форматування
+func ParseModeByName(name string) (ParseMode, bool) {
+	switch mode := ParseMode(strings.ToLower(name)); mode {
+	case ParseModeNone, ParseModeMarkdown, ParseModeHTML:
+		return mode, true
+	}
+	return "", false
+}
+
+// ParseError - помилка в розмітці тексту
+type ParseError struct {
+	// Позиція в символах від початку тексту
+	Offset int
+	Reason string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s at position %d", e.Reason, e.Offset)
+}
+
+// PrepareText розбирає розмітку тексту і замінює @username відомих користувачів
+// на згадки за ID (InputMessageEntityMentionName) без звернень до Telegram
+func (c *Client) PrepareText(text string, mode ParseMode) (string, []tg.MessageEntityClass, error) {
+	text, entities, err := ParseText(text, mode)
+	if err != nil || mode == ParseModeNone {
+		return text, entities, err
+	}
+	return text, c.resolveMentions(text, entities), nil
+}
+
+// ParseText розбирає розмітку без звернень до Telegram (без згадок)
+func ParseText(text string, mode ParseMode) (string, []tg.MessageEntityClass, error) {
+	switch mode {
+	case ParseModeMarkdown:
+		return ParseMarkdownText(text)
+	case ParseModeHTML:
+		return ParseHTMLText(text)
+	}
+	return text, nil, nil
+}
+
+var mentionPattern = regexp.MustCompile(`(^|[^\w@])@([A-Za-z][A-Za-z0-9_]{3,31})\b`)
+
+// resolveMentions додає згадки користувачів за ID для @username поза кодом і посиланнями.
+// Імена шукаються лише серед уже відомих співрозмовників (див. PeerStore): невідомі
+// імена, канали і групи лишаються текстом - їх розпізнає сам Telegram.
+func (c *Client) resolveMentions(text string, entities []tg.MessageEntityClass) []tg.MessageEntityClass {
+	// Ділянки, де згадки не шукаються
+	var skip [][2]int
+	for _, e := range entities {
+		switch e.(type) {
+		case *tg.MessageEntityCode, *tg.MessageEntityPre, *tg.MessageEntityTextURL:
+			skip = append(skip, [2]int{e.GetOffset(), e.GetOffset() + e.GetLength()})
+		}
+	}
+
+	for _, match := range mentionPattern.FindAllStringSubmatchIndex(text, -1) {
+		start, end := match[4]-1, match[5] // разом з @
+		offset := utf16Len(text[:start])
+		length := utf16Len(text[start:end])
+
+		skipped := false
+		for _, r := range skip {
+			if offset < r[1] && offset+length > r[0] {
+				skipped = true
+				break
+			}
+		}
+		if skipped {
+			continue
+		}
+
+		peer, ok := c.peers.Username(text[start+1 : end])
+		if !ok {
+			continue
+		}
+		user, ok := peer.(*tg.InputPeerUser)
+		if !ok {
+			continue
+		}
+		entities = append(entities, &tg.InputMessageEntityMentionName{
+			Offset: offset,
+			Length: length,
+			UserID: &tg.InputUser{UserID: user.UserID, AccessHash: user.AccessHash},
+		})
+	}
+	return entities
+}
+
+func utf16Len(s string) int {
+	n := 0
+	for _, r := range s {
+		n += utf16.RuneLen(r)
+	}
+	return n
+}
+
+// textBuilder збирає текст без розмітки і рахує зсуви в одиницях UTF-16
+type textBuilder struct {
+	b        strings.Builder
+	pos      int
+	entities []tg.MessageEntityClass
+}
+
+func (t *textBuilder) WriteRune(r rune) {
+	t.b.WriteRune(r)
+	t.pos += utf16.RuneLen(r)
+}
+
+func (t *textBuilder) WriteString(s string) {
+	t.b.WriteString(s)
+	t.pos += utf16Len(s)
+}
+
+// add додає сутність від start до поточної позиції; порожні пропускаються
+func (t *textBuilder) add(start int, build func(offset, length int) tg.MessageEntityClass) {
+	if t.pos > start {
+		t.entities = append(t.entities, build(start, t.pos-start))
+	}
+}
+
+// markdownMarker - відкритий маркер Markdown
+type markdownMarker struct {
+	token string
+	start int // зсув у тексті без розмітки (UTF-16)
+	at    int // позиція маркера у вхідному тексті (символи)
+}
+
+// markdownTokens - парні маркери; довші перевіряються першими
+var markdownTokens = []string{"**", "__", "~~", "||", "*", "_"}
+
+func markdownEntity(token string, offset, length int) tg.MessageEntityClass {
+	switch token {
+	case "**":
+		return &tg.MessageEntityBold{Offset: offset, Length: length}
+	case "__":
+		return &tg.MessageEntityUnderline{Offset: offset, Length: length}
+	case "~~":
+		return &tg.MessageEntityStrike{Offset: offset, Length: length}
+	case "||":
+		return &tg.MessageEntitySpoiler{Offset: offset, Length: length}
+	}
+	return &tg.MessageEntityItalic{Offset: offset, Length: length}
+}
+
+// ParseMarkdownText розбирає Markdown-подібну розмітку:
+// **жирний**, *курсив* або _курсив_, __підкреслений__, ~~закреслений~~,
+// ||спойлер||, `код`, ```мова\nблок коду```, [текст](адреса).
+// Символ після \ завжди береться буквально, як і [...] без (адреси) після ].
+func ParseMarkdownText(input string) (string, []tg.MessageEntityClass, error) {
+	runes := []rune(input)
+	var t textBuilder
+	var open []markdownMarker
+	var link *markdownMarker
+
+	isSpace := func(i int) bool {
+		return i < 0 || i >= len(runes) || unicode.IsSpace(runes[i])
+	}
+	isWord := func(i int) bool {
+		return i >= 0 && i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]))
+	}
+	// linkAhead повідомляє, чи [ на позиції i відкриває посилання: перша
+	// незаекранована ] після неї стоїть перед (
+	linkAhead := func(i int) bool {
+		for j := i + 1; j < len(runes); j++ {
+			switch runes[j] {
+			case '\\':
+				j++
+			case ']':
+				return j+1 < len(runes) && runes[j+1] == '('
+			}
+		}
+		return false
+	}
+	hasPrefix := func(i int, token string) bool {
+		for _, r := range token {
+			if i >= len(runes) || runes[i] != r {
+				return false
+			}
+			i++
+		}
+		return true
+	}
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+
+		switch {
+		case r == '\\' && i+1 < len(runes):
+			t.WriteRune(runes[i+1])
+			i += 2
+			continue
+
+		case hasPrefix(i, "```"):
+			end := strings.Index(string(runes[i+3:]), "```")
+			if end < 0 {
+				return "", nil, &ParseError{Offset: i, Reason: "unclosed ```"}
+			}
+			raw := string(runes[i+3:])[:end]
+			body := raw
+			language := ""
+			if nl := strings.IndexByte(body, '\n'); nl >= 0 && isLanguage(body[:nl]) {
+				language = body[:nl]
+				body = body[nl+1:]
+			}
+			// Перенос рядка перед закриваючими ``` до коду не належить
+			body = strings.TrimSuffix(body, "\n")
+			start := t.pos
+			t.WriteString(body)
+			t.add(start, func(offset, length int) tg.MessageEntityClass {
+				return &tg.MessageEntityPre{Offset: offset, Length: length, Language: language}
+			})
+			i += 3 + utf8.RuneCountInString(raw) + 3
+			continue
+
+		case r == '`':
+			end := strings.IndexRune(string(runes[i+1:]), '`')
+			if end < 0 {
+				return "", nil, &ParseError{Offset: i, Reason: "unclosed `"}
+			}
+			body := string(runes[i+1:])[:end]
+			start := t.pos
+			t.WriteString(body)
+			t.add(start, func(offset, length int) tg.MessageEntityClass {
+				return &tg.MessageEntityCode{Offset: offset, Length: length}
+			})
+			i += 1 + utf8.RuneCountInString(body) + 1
+			continue
+
+		case r == '[' && link == nil && linkAhead(i):
+			link = &markdownMarker{token: "[", start: t.pos, at: i}
+			i++
+			continue
+
+		case r == ']' && link != nil && i+1 < len(runes) && runes[i+1] == '(':
+			end := strings.IndexRune(string(runes[i+2:]), ')')
+			if end < 0 {
+				return "", nil, &ParseError{Offset: i + 1, Reason: "unclosed ("}
+			}
+			target := strings.TrimSpace(string(runes[i+2:])[:end])
+			if target == "" {
+				return "", nil, &ParseError{Offset: i + 1, Reason: "empty link url"}
+			}
+			t.add(link.start, func(offset, length int) tg.MessageEntityClass {
+				return &tg.MessageEntityTextURL{Offset: offset, Length: length, URL: target}
+			})
+			link = nil
+			i += 2 + utf8.RuneCountInString(string(runes[i+2:])[:end]) + 1
+			continue
+		}
+
+		token := ""
+		for _, candidate := range markdownTokens {
+			if hasPrefix(i, candidate) {
+				token = candidate
+				break
+			}
+		}
+		if token == "" {
+			t.WriteRune(r)
+			i++
+			continue
+		}
+
+		size := len(token)
+		idx := -1
+		for k := len(open) - 1; k >= 0; k-- {
+			if open[k].token == token {
+				idx = k
+				break
+			}
+		}
+
+		// Одиночні * і _ - лише біля слова, щоб не чіпати snake_case і "2 * 3"
+		if size == 1 {
+			closing := idx >= 0 && !isSpace(i-1)
+			opening := idx < 0 && !isSpace(i+1)
+			if token == "_" && isWord(i-1) && isWord(i+1) {
+				closing, opening = false, false
+			}
+			if !closing && !opening {
+				t.WriteRune(r)
+				i++
+				continue
+			}
+		}
+
+		if idx >= 0 {
+			marker := open[idx]
+			t.add(marker.start, func(offset, length int) tg.MessageEntityClass {
+				return markdownEntity(token, offset, length)
+			})
+			open = append(open[:idx], open[idx+1:]...)
+		} else {
+			open = append(open, markdownMarker{token: token, start: t.pos, at: i})
+		}
+		i += size
+	}
+
+	if link != nil {
+		return "", nil, &ParseError{Offset: link.at, Reason: "unclosed ["}
+	}
+	if len(open) > 0 {
+		marker := open[0]
+		return "", nil, &ParseError{Offset: marker.at, Reason: fmt.Sprintf("unclosed %s", marker.token)}
+	}
+	return t.b.String(), t.entities, nil
+}
+
+func isLanguage(s string) bool {
+	if s == "" || len(s) > 32 {
+		return false
+	}
+	for _, r := range s {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune("+#-_.", r) {
+			return false
+		}
+	}
+	return true
+}
+
+// htmlMarker - відкритий HTML тег
+type htmlMarker struct {
+	tag   string
+	start int
+	at    int
+	attrs map[string]string
+}
+
+var htmlTagPattern = regexp.MustCompile(`^<(/?)([a-zA-Z][a-zA-Z0-9-]*)((?:\s+[a-zA-Z-]+\s*=\s*(?:"[^"]*"|'[^']*'))*)\s*(/?)>`)
+var htmlAttrPattern = regexp.MustCompile(`([a-zA-Z-]+)\s*=\s*(?:"([^"]*)"|'([^']*)')`)
+
+// Теги HTML і їхні синоніми
+var htmlTags = map[string]string{
+	"b": "b", "strong": "b",
+	"i": "i", "em": "i",
+	"u": "u", "ins": "u",
+	"s": "s", "strike": "s", "del": "s",
+	"code": "code", "pre": "pre",
+	"a":          "a",
+	"tg-spoiler": "spoiler", "span": "spoiler",
+	"blockquote": "blockquote",
+}
+
+// ParseHTMLText розбирає HTML-розмітку: <b>, <i>, <u>, <s>, <code>, <pre>,
+// <a href="...">, <tg-spoiler> або <span class="tg-spoiler">, <blockquote>, <br>.
+// Символ < поза тегом треба писати як &lt;.
+func ParseHTMLText(input string) (string, []tg.MessageEntityClass, error) {
+	var t textBuilder
+	var open []htmlMarker
+
+	pos := 0 // позиція у вхідному тексті, символи
+	for len(input) > 0 {
+		lt := strings.IndexByte(input, '<')
+		if lt < 0 {
+			lt = len(input)
+		}
+		if lt > 0 {
+			chunk := input[:lt]
+			t.WriteString(html.UnescapeString(chunk))
+			pos += utf8.RuneCountInString(chunk)
+			input = input[lt:]
+			continue
+		}
+
+		match := htmlTagPattern.FindStringSubmatch(input)
+		if match == nil {
+			return "", nil, &ParseError{Offset: pos, Reason: "invalid tag (use &lt; for a literal <)"}
+		}
+		closing, name, rawAttrs, selfClosing := match[1] == "/", strings.ToLower(match[2]), match[3], match[4] == "/"
+		at := pos
+		pos += utf8.RuneCountInString(match[0])
+		input = input[len(match[0]):]
+
+		if name == "br" {
+			t.WriteRune('\n')
+			continue
+		}
+		tag, ok := htmlTags[name]
+		if !ok {
+			return "", nil, &ParseError{Offset: at, Reason: fmt.Sprintf("unsupported tag <%s>", name)}
+		}
+		if selfClosing {
+			return "", nil, &ParseError{Offset: at, Reason: fmt.Sprintf("<%s/> must have content", name)}
+		}
+
+		if !closing {
+			attrs := make(map[string]string)
+			for _, attr := range htmlAttrPattern.FindAllStringSubmatch(rawAttrs, -1) {
+				attrs[strings.ToLower(attr[1])] = html.UnescapeString(attr[2] + attr[3])
+			}
+			if name == "span" && attrs["class"] != "tg-spoiler" {
+				return "", nil, &ParseError{Offset: at, Reason: `only <span class="tg-spoiler"> is supported`}
+			}
+			if tag == "a" && strings.TrimSpace(attrs["href"]) == "" {
+				return "", nil, &ParseError{Offset: at, Reason: "<a> without href"}
+			}
+			open = append(open, htmlMarker{tag: tag, start: t.pos, at: at, attrs: attrs})
+			continue
+		}
+
+		if len(open) == 0 || open[len(open)-1].tag != tag {
+			return "", nil, &ParseError{Offset: at, Reason: fmt.Sprintf("unexpected </%s>", name)}
+		}
+		marker := open[len(open)-1]
+		open = open[:len(open)-1]
+
+		// <pre><code class="language-go"> - мова блоку коду
+		if tag == "code" && len(open) > 0 && open[len(open)-1].tag == "pre" {
+			if language := strings.TrimPrefix(marker.attrs["class"], "language-"); language != marker.attrs["class"] {
+				open[len(open)-1].attrs["language"] = language
+			}
+			continue
+		}
+
+		t.add(marker.start, func(offset, length int) tg.MessageEntityClass {
+			return htmlEntity(marker, offset, length)
+		})
+	}
+
+	if len(open) > 0 {
+		marker := open[len(open)-1]
+		return "", nil, &ParseError{Offset: marker.at, Reason: fmt.Sprintf("unclosed <%s>", marker.tag)}
+	}
+	return t.b.String(), t.entities, nil
+}
+
+func htmlEntity(marker htmlMarker, offset, length int) tg.MessageEntityClass {
+	switch marker.tag {
+	case "b":
+		return &tg.MessageEntityBold{Offset: offset, Length: length}
+	case "i":
+		return &tg.MessageEntityItalic{Offset: offset, Length: length}
+	case "u":
+		return &tg.MessageEntityUnderline{Offset: offset, Length: length}
+	case "s":
+		return &tg.MessageEntityStrike{Offset: offset, Length: length}
+	case "code":
+		return &tg.MessageEntityCode{Offset: offset, Length: length}
+	case "pre":
+		return &tg.MessageEntityPre{Offset: offset, Length: length, Language: marker.attrs["language"]}
+	case "a":
+		return &tg.MessageEntityTextURL{Offset: offset, Length: length, URL: strings.TrimSpace(marker.attrs["href"])}
+	case "spoiler":
+		return &tg.MessageEntitySpoiler{Offset: offset, Length: length}
+	}
+	return &tg.MessageEntityBlockquote{Offset: offset, Length: length}
+}
@@ -0,0 +1,286 @@
+package telegram
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/gotd/td/tg"
+)
+
+func TestParseMarkdownText(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		text     string
+		entities []tg.MessageEntityClass
+	}{
+		{
+			name:  "plain text",
+			input: "hello",
+			text:  "hello",
+		},
+		{
+			name:     "bold",
+			input:    "hi **there**",
+			text:     "hi there",
+			entities: []tg.MessageEntityClass{&tg.MessageEntityBold{Offset: 3, Length: 5}},
+		},
+		{
+			name:  "all paired markers",
+			input: "*i* _i_ __u__ ~~s~~ ||p||",
+			text:  "i i u s p",
+			entities: []tg.MessageEntityClass{
+				&tg.MessageEntityItalic{Offset: 0, Length: 1},
+				&tg.MessageEntityItalic{Offset: 2, Length: 1},
+				&tg.MessageEntityUnderline{Offset: 4, Length: 1},
+				&tg.MessageEntityStrike{Offset: 6, Length: 1},
+				&tg.MessageEntitySpoiler{Offset: 8, Length: 1},
+			},
+		},
+		{
+			// 😀 займає дві одиниці UTF-16
+			name:     "offsets after emoji",
+			input:    "😀 **hi**",
+			text:     "😀 hi",
+			entities: []tg.MessageEntityClass{&tg.MessageEntityBold{Offset: 3, Length: 2}},
+		},
+		{
+			name:     "emoji inside entity",
+			input:    "**a😀b**",
+			text:     "a😀b",
+			entities: []tg.MessageEntityClass{&tg.MessageEntityBold{Offset: 0, Length: 4}},
+		},
+		{
+			// У "***" спершу закривається довший маркер **
+			name:  "nested markers",
+			input: "**bold *both***",
+			text:  "bold both",
+			entities: []tg.MessageEntityClass{
+				&tg.MessageEntityBold{Offset: 0, Length: 9},
+				&tg.MessageEntityItalic{Offset: 5, Length: 4},
+			},
+		},
+		{
+			name:  "nested markers closed in order",
+			input: "**bold _both_**",
+			text:  "bold both",
+			entities: []tg.MessageEntityClass{
+				&tg.MessageEntityItalic{Offset: 5, Length: 4},
+				&tg.MessageEntityBold{Offset: 0, Length: 9},
+			},
+		},
+		{
+			name:  "overlapping markers",
+			input: "**ab~~cd**ef~~",
+			text:  "abcdef",
+			entities: []tg.MessageEntityClass{
+				&tg.MessageEntityBold{Offset: 0, Length: 4},
+				&tg.MessageEntityStrike{Offset: 2, Length: 4},
+			},
+		},
+		{
+			name:  "single markers inside words and between spaces",
+			input: "snake_case and 2 * 3",
+			text:  "snake_case and 2 * 3",
+		},
+		{
+			name:  "escapes",
+			input: `\*not\* \[x\] \\`,
+			text:  `*not* [x] \`,
+		},
+		{
+			name:     "inline code keeps markers",
+			input:    "run `a **b**`",
+			text:     "run a **b**",
+			entities: []tg.MessageEntityClass{&tg.MessageEntityCode{Offset: 4, Length: 7}},
+		},
+		{
+			name:     "code block with language",
+			input:    "```go\nfmt.Println()\n```",
+			text:     "fmt.Println()",
+			entities: []tg.MessageEntityClass{&tg.MessageEntityPre{Offset: 0, Length: 13, Language: "go"}},
+		},
+		{
+			name:     "link",
+			input:    "see [the docs](https://example.com)!",
+			text:     "see the docs!",
+			entities: []tg.MessageEntityClass{&tg.MessageEntityTextURL{Offset: 4, Length: 8, URL: "https://example.com"}},
+		},
+		{
+			name:  "brackets without url are literal",
+			input: "see [1] and [x] done, a [b",
+			text:  "see [1] and [x] done, a [b",
+		},
+		{
+			name:  "bold link",
+			input: "**[go](https://go.dev)**",
+			text:  "go",
+			entities: []tg.MessageEntityClass{
+				&tg.MessageEntityTextURL{Offset: 0, Length: 2, URL: "https://go.dev"},
+				&tg.MessageEntityBold{Offset: 0, Length: 2},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text, entities, err := ParseMarkdownText(tt.input)
+			if err != nil {
+				t.Fatalf("ParseMarkdownText() error = %v", err)
+			}
+			if text != tt.text {
+				t.Errorf("text = %q, want %q", text, tt.text)
+			}
+			if !reflect.DeepEqual(entities, tt.entities) {
+				t.Errorf("entities = %#v, want %#v", entities, tt.entities)
+			}
+		})
+	}
+}
+
+func TestParseMarkdownTextErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		offset int
+		reason string
+	}{
+		{name: "unclosed bold", input: "a **b", offset: 2, reason: "unclosed **"},
+		{name: "unclosed italic after emoji", input: "😀 *b", offset: 2, reason: "unclosed *"},
+		{name: "unclosed code", input: "a `b", offset: 2, reason: "unclosed `"},
+		{name: "unclosed code block", input: "```go\nx", offset: 0, reason: "unclosed ```"},
+		{name: "link closed inside code", input: "[a `](x)` b] c", offset: 0, reason: "unclosed ["},
+		{name: "unclosed url", input: "[b](http://x", offset: 3, reason: "unclosed ("},
+		{name: "empty url", input: "[b]( )", offset: 3, reason: "empty link url"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := ParseMarkdownText(tt.input)
+			var parseErr *ParseError
+			if !errors.As(err, &parseErr) {
+				t.Fatalf("ParseMarkdownText() error = %v, want ParseError", err)
+			}
+			if parseErr.Offset != tt.offset || parseErr.Reason != tt.reason {
+				t.Errorf("error = %d %q, want %d %q", parseErr.Offset, parseErr.Reason, tt.offset, tt.reason)
+			}
+		})
+	}
+}
+
+func TestParseHTMLText(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		text     string
+		entities []tg.MessageEntityClass
+	}{
+		{
+			name:  "entities are unescaped",
+			input: "a &lt; b &amp;&amp; c &gt; d",
+			text:  "a < b && c > d",
+		},
+		{
+			name:  "tags and synonyms",
+			input: "<b>b</b><strong>b</strong><em>i</em><ins>u</ins><del>s</del>",
+			text:  "bbius",
+			entities: []tg.MessageEntityClass{
+				&tg.MessageEntityBold{Offset: 0, Length: 1},
+				&tg.MessageEntityBold{Offset: 1, Length: 1},
+				&tg.MessageEntityItalic{Offset: 2, Length: 1},
+				&tg.MessageEntityUnderline{Offset: 3, Length: 1},
+				&tg.MessageEntityStrike{Offset: 4, Length: 1},
+			},
+		},
+		{
+			// 😀 займає дві одиниці UTF-16
+			name:     "offsets after emoji",
+			input:    "😀<i>hi</i>",
+			text:     "😀hi",
+			entities: []tg.MessageEntityClass{&tg.MessageEntityItalic{Offset: 2, Length: 2}},
+		},
+		{
+			name:  "nested tags",
+			input: "<b>bold <i>both</i></b>",
+			text:  "bold both",
+			entities: []tg.MessageEntityClass{
+				&tg.MessageEntityItalic{Offset: 5, Length: 4},
+				&tg.MessageEntityBold{Offset: 0, Length: 9},
+			},
+		},
+		{
+			name:     "pre with language",
+			input:    `<pre><code class="language-go">x := 1</code></pre>`,
+			text:     "x := 1",
+			entities: []tg.MessageEntityClass{&tg.MessageEntityPre{Offset: 0, Length: 6, Language: "go"}},
+		},
+		{
+			name:     "link with escaped href",
+			input:    `<a href="https://e.com/?a=1&amp;b=2">docs</a>`,
+			text:     "docs",
+			entities: []tg.MessageEntityClass{&tg.MessageEntityTextURL{Offset: 0, Length: 4, URL: "https://e.com/?a=1&b=2"}},
+		},
+		{
+			name:  "spoilers and blockquote",
+			input: `<tg-spoiler>a</tg-spoiler><span class="tg-spoiler">b</span><blockquote>c</blockquote>`,
+			text:  "abc",
+			entities: []tg.MessageEntityClass{
+				&tg.MessageEntitySpoiler{Offset: 0, Length: 1},
+				&tg.MessageEntitySpoiler{Offset: 1, Length: 1},
+				&tg.MessageEntityBlockquote{Offset: 2, Length: 1},
+			},
+		},
+		{
+			name:  "line break",
+			input: "a<br>b<br/>c",
+			text:  "a\nb\nc",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text, entities, err := ParseHTMLText(tt.input)
+			if err != nil {
+				t.Fatalf("ParseHTMLText() error = %v", err)
+			}
+			if text != tt.text {
+				t.Errorf("text = %q, want %q", text, tt.text)
+			}
+			if !reflect.DeepEqual(entities, tt.entities) {
+				t.Errorf("entities = %#v, want %#v", entities, tt.entities)
+			}
+		})
+	}
+}
+
+func TestParseHTMLTextErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		offset int
+		reason string
+	}{
+		{name: "unclosed tag", input: "a <b>b", offset: 2, reason: "unclosed <b>"},
+		{name: "unclosed tag after emoji", input: "😀<i>x", offset: 1, reason: "unclosed <i>"},
+		{name: "overlapping tags", input: "<b><i>x</b></i>", offset: 7, reason: "unexpected </b>"},
+		{name: "stray closing tag", input: "x</u>", offset: 1, reason: "unexpected </u>"},
+		{name: "unsupported tag", input: "<div>x</div>", offset: 0, reason: "unsupported tag <div>"},
+		{name: "literal less-than", input: "a < b", offset: 2, reason: "invalid tag (use &lt; for a literal <)"},
+		{name: "link without href", input: "<a>x</a>", offset: 0, reason: "<a> without href"},
+		{name: "plain span", input: "<span>x</span>", offset: 0, reason: `only <span class="tg-spoiler"> is supported`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := ParseHTMLText(tt.input)
+			var parseErr *ParseError
+			if !errors.As(err, &parseErr) {
+				t.Fatalf("ParseHTMLText() error = %v, want ParseError", err)
+			}
+			if parseErr.Offset != tt.offset || parseErr.Reason != tt.reason {
+				t.Errorf("error = %d %q, want %d %q", parseErr.Offset, parseErr.Reason, tt.offset, tt.reason)
+			}
+		})
+	}
+}
@@ -0,0 +1,388 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/gotd/td/tg"
+)
+
+// PeerKind - тип співрозмовника
+type PeerKind int
+
+const (
+	PeerUser PeerKind = iota + 1
+	PeerChat
+	PeerChannel
+)
+
+// channelIDOffset - зсув для позначених ID каналів (-100xxxxxxxxxx), як у Bot API
+const channelIDOffset = 1000000000000
+
+// MarkedPeerID повертає однозначний ID співрозмовника:
+// користувачі - додатні, звичайні групи - від'ємні, канали - -100...
+func MarkedPeerID(peer tg.PeerClass) int64 {
+	switch p := peer.(type) {
+	case *tg.PeerUser:
+		return p.UserID
+	case *tg.PeerChat:
+		return -p.ChatID
+	case *tg.PeerChannel:
+		return -(channelIDOffset + p.ChannelID)
+	}
+	return 0
+}
+
+// InputPeerID повертає позначений ID для InputPeer; self - ID власного акаунта
+func InputPeerID(peer tg.InputPeerClass, self int64) (int64, bool) {
+	switch p := peer.(type) {
+	case *tg.InputPeerSelf:
+		return self, self != 0
+	case *tg.InputPeerUser:
+		return p.UserID, true
+	case *tg.InputPeerUserFromMessage:
+		return p.UserID, true
+	case *tg.InputPeerChat:
+		return -p.ChatID, true
+	case *tg.InputPeerChannel:
+		return -(channelIDOffset + p.ChannelID), true
+	case *tg.InputPeerChannelFromMessage:
+		return -(channelIDOffset + p.ChannelID), true
+	}
+	return 0, false
+}
+
+// ParseChatID розбирає позначений ID на тип і сирий ID
+func ParseChatID(chatID int64) (PeerKind, int64) {
+	switch {
+	case chatID > 0:
+		return PeerUser, chatID
+	case chatID < -channelIDOffset:
+		return PeerChannel, -chatID - channelIDOffset
+	default:
+		return PeerChat, -chatID
+	}
+}
+
+// RawChatID перетворює позначений ID на сирий (для старих клієнтів)
+func RawChatID(chatID int64) int64 {
+	_, id := ParseChatID(chatID)
+	return id
+}
+
+// PeerStore зберігає access hash користувачів і каналів акаунта.
+// Заповнюється з кожного списку Users/Chats, що проходить через шлюз.
+type PeerStore struct {
+	mu        sync.RWMutex
+	users     map[int64]int64
+	profiles  map[int64]*tg.User
+	chats     map[int64]struct{}
+	channels  map[int64]int64
+	usernames map[string]tg.InputPeerClass
+	// Назви груп і каналів за позначеним ID
+	titles map[int64]string
+}
+
+// NewPeerStore створює порожнє сховище співрозмовників
+func NewPeerStore() *PeerStore {
+	return &PeerStore{
+		users:     make(map[int64]int64),
+		profiles:  make(map[int64]*tg.User),
+		chats:     make(map[int64]struct{}),
+		channels:  make(map[int64]int64),
+		usernames: make(map[string]tg.InputPeerClass),
+		titles:    make(map[int64]string),
+	}
+}
+
+// Apply запам'ятовує користувачів і чати з відповіді Telegram
+func (s *PeerStore) Apply(users []tg.UserClass, chats []tg.ChatClass) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, u := range users {
+		user, ok := u.(*tg.User)
+		if !ok {
+			continue
+		}
+		// У min-конструкторів access hash не придатний для запитів:
+		// беремо лише профіль, якщо повного ще немає
+		if user.Min {
+			if _, known := s.profiles[user.ID]; !known {
+				s.profiles[user.ID] = user
+			}
+			continue
+		}
+		s.users[user.ID] = user.AccessHash
+		s.profiles[user.ID] = user
+		peer := &tg.InputPeerUser{UserID: user.ID, AccessHash: user.AccessHash}
+		if user.Username != "" {
+			s.usernames[strings.ToLower(user.Username)] = peer
+		}
+		// Додаткові (колекційні) username
+		for _, name := range user.Usernames {
+			if name.Active {
+				s.usernames[strings.ToLower(name.Username)] = peer
+			}
+		}
+	}
+
+	for _, c := range chats {
+		if title := GetChatTitle(c); title != "Unknown" {
+			s.titles[chatMarkedID(c)] = title
+		}
+		switch chat := c.(type) {
+		case *tg.Chat:
+			s.chats[chat.ID] = struct{}{}
+		case *tg.ChatForbidden:
+			s.chats[chat.ID] = struct{}{}
+		case *tg.Channel:
+			if chat.Min {
+				continue
+			}
+			s.channels[chat.ID] = chat.AccessHash
+			if chat.Username != "" {
+				s.usernames[strings.ToLower(chat.Username)] = &tg.InputPeerChannel{ChannelID: chat.ID, AccessHash: chat.AccessHash}
+			}
+		case *tg.ChannelForbidden:
+			s.channels[chat.ID] = chat.AccessHash
+		}
+	}
+}
+
+// Lookup будує InputPeer для відомого співрозмовника заданого типу
+func (s *PeerStore) Lookup(kind PeerKind, id int64) (tg.InputPeerClass, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	switch kind {
+	case PeerUser:
+		if hash, ok := s.users[id]; ok {
+			return &tg.InputPeerUser{UserID: id, AccessHash: hash}, true
+		}
+	case PeerChat:
+		if _, ok := s.chats[id]; ok {
+			return &tg.InputPeerChat{ChatID: id}, true
+		}
+	case PeerChannel:
+		if hash, ok := s.channels[id]; ok {
+			return &tg.InputPeerChannel{ChannelID: id, AccessHash: hash}, true
+		}
+	}
+	return nil, false
+}
+
+// Find шукає співрозмовника за ID серед усіх типів
+func (s *PeerStore) Find(id int64) (tg.InputPeerClass, bool) {
+	for _, kind := range []PeerKind{PeerUser, PeerChat, PeerChannel} {
+		if peer, ok := s.Lookup(kind, id); ok {
+			return peer, true
+		}
+	}
+	return nil, false
+}
+
+// Title повертає останню відому назву групи або каналу
+func (s *PeerStore) Title(chatID int64) (string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	title, ok := s.titles[chatID]
+	return title, ok
+}
+
+// chatMarkedID повертає позначений ID групи або каналу
+func chatMarkedID(chat tg.ChatClass) int64 {
+	switch chat.(type) {
+	case *tg.Channel, *tg.ChannelForbidden:
+		return MarkedPeerID(&tg.PeerChannel{ChannelID: chat.GetID()})
+	}
+	return MarkedPeerID(&tg.PeerChat{ChatID: chat.GetID()})
+}
+
+// User повертає останній відомий профіль користувача
+func (s *PeerStore) User(id int64) (*tg.User, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user, ok := s.profiles[id]
+	return user, ok
+}
+
+// Username шукає співрозмовника за username (без @)
+func (s *PeerStore) Username(username string) (tg.InputPeerClass, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	peer, ok := s.usernames[strings.ToLower(username)]
+	return peer, ok
+}
+
+// ChannelAccessHash повертає access hash каналу
+func (s *PeerStore) ChannelAccessHash(id int64) (int64, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	hash, ok := s.channels[id]
+	return hash, ok
+}
+
+// SetChannelAccessHash реалізує updates.ChannelAccessHasher
+func (s *PeerStore) SetChannelAccessHash(_ context.Context, _, channelID, accessHash int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.channels[channelID] = accessHash
+	return nil
+}
+
+// GetChannelAccessHash реалізує updates.ChannelAccessHasher
+func (s *PeerStore) GetChannelAccessHash(_ context.Context, _, channelID int64) (int64, bool, error) {
+	hash, ok := s.ChannelAccessHash(channelID)
+	return hash, ok, nil
+}
+
+// SameChat порівнює позначений ID чату з ID, який передав клієнт
+// (клієнт у режимі сумісності може передати сирий ID)
+func SameChat(chatID, requested int64) bool {
+	return chatID == requested || requested > 0 && RawChatID(chatID) == requested
+}
+
+// applyUpdates запам'ятовує співрозмовників з пакета оновлень
+func (s *PeerStore) applyUpdates(u tg.UpdatesClass) {
+	switch upd := u.(type) {
+	case *tg.Updates:
+		s.Apply(upd.Users, upd.Chats)
+	case *tg.UpdatesCombined:
+		s.Apply(upd.Users, upd.Chats)
+	}
+}
+
+// InputChannel перетворює InputPeerChannel на InputChannel
+func InputChannel(peer tg.InputPeerClass) (*tg.InputChannel, bool) {
+	channel, ok := peer.(*tg.InputPeerChannel)
+	if !ok {
+		return nil, false
+	}
+	return &tg.InputChannel{ChannelID: channel.ChannelID, AccessHash: channel.AccessHash}, true
+}
+
+// ErrPeerNotFound повертається, якщо чат невідомий акаунту
+var ErrPeerNotFound = errors.New("peer not found")
+
+// GetInputPeer створює InputPeer для чату з урахуванням access hash.
+// chatID - позначений ID (див. MarkedPeerID); сирі ID груп і каналів
+// від старих клієнтів теж приймаються.
+// Якщо співрозмовник невідомий - підвантажує діалоги і шукає ще раз.
+func (c *Client) GetInputPeer(ctx context.Context, chatID int64) (tg.InputPeerClass, error) {
+	if peer, ok := c.findPeer(chatID); ok {
+		return peer, nil
+	}
+
+	if err := c.loadPeers(ctx, chatID); err != nil {
+		return nil, err
+	}
+
+	if peer, ok := c.findPeer(chatID); ok {
+		return peer, nil
+	}
+	return nil, fmt.Errorf("%w: %d", ErrPeerNotFound, chatID)
+}
+
+func (c *Client) findPeer(chatID int64) (tg.InputPeerClass, bool) {
+	if peer, ok := c.peers.Lookup(ParseChatID(chatID)); ok {
+		return peer, true
+	}
+
+	// Сумісність: сирий ID групи або каналу
+	if chatID > 0 {
+		return c.peers.Find(chatID)
+	}
+	return nil, false
+}
+
+// ResolveUsername знаходить співрозмовника за username через contacts.resolveUsername
+func (c *Client) ResolveUsername(ctx context.Context, username string) (tg.InputPeerClass, error) {
+	username = strings.TrimPrefix(username, "@")
+	if peer, ok := c.peers.Username(username); ok {
+		return peer, nil
+	}
+
+	var peer tg.InputPeerClass
+	err := c.run(ctx, func(ctx context.Context) error {
+		resolved, err := c.Client.API().ContactsResolveUsername(ctx, &tg.ContactsResolveUsernameRequest{
+			Username: username,
+		})
+		if err != nil {
+			return fmt.Errorf("resolve username error: %w", err)
+		}
+		c.peers.Apply(resolved.Users, resolved.Chats)
+
+		var ok bool
+		switch p := resolved.Peer.(type) {
+		case *tg.PeerUser:
+			peer, ok = c.peers.Lookup(PeerUser, p.UserID)
+		case *tg.PeerChat:
+			peer, ok = c.peers.Lookup(PeerChat, p.ChatID)
+		case *tg.PeerChannel:
+			peer, ok = c.peers.Lookup(PeerChannel, p.ChannelID)
+		}
+		if !ok {
+			return fmt.Errorf("username @%s not resolved", username)
+		}
+		return nil
+	})
+	return peer, err
+}
+
+// Скільки сторінок діалогів щонайбільше гортається в пошуках невідомого чату
+const peerScanPages = 10
+
+// loadPeers гортає діалоги, доки не знайдеться чат chatID, заповнюючи
+// сховище співрозмовників
+func (c *Client) loadPeers(ctx context.Context, chatID int64) error {
+	return c.run(ctx, func(ctx context.Context) error {
+		request := &tg.MessagesGetDialogsRequest{
+			OffsetPeer: &tg.InputPeerEmpty{},
+			Limit:      100,
+		}
+		seen := 0
+		for page := 0; page < peerScanPages; page++ {
+			result, err := c.Client.API().MessagesGetDialogs(ctx, request)
+			if err != nil {
+				return fmt.Errorf("get dialogs error: %w", err)
+			}
+
+			modified, ok := result.AsModified()
+			if !ok {
+				return nil
+			}
+			c.peers.Apply(modified.GetUsers(), modified.GetChats())
+			if _, found := c.findPeer(chatID); found {
+				return nil
+			}
+
+			// messages.dialogs - усі діалоги вже отримано
+			slice, ok := result.(*tg.MessagesDialogsSlice)
+			if !ok {
+				return nil
+			}
+			next, more := nextDialogCursor(slice, seen)
+			if !more {
+				return nil
+			}
+			offsetPeer, ok := c.findPeer(next.OffsetPeer)
+			if !ok {
+				return nil
+			}
+			seen = next.Seen
+			request.OffsetDate = next.OffsetDate
+			request.OffsetID = next.OffsetID
+			request.OffsetPeer = offsetPeer
+		}
+		return nil
+	})
+}
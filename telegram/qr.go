@@ -0,0 +1,46 @@
+package telegram
+
+import (
+	"image"
+	"image/color"
+
+	"rsc.io/qr"
+)
+
+// Біла рамка навколо QR-коду в модулях (вимога стандарту)
+const qrQuietZone = 4
+
+// RenderQR малює QR-код не більше size пікселів по стороні.
+// Модуль займає ціле число пікселів, щоб код лишався чітким
+// після масштабування на маленьких екранах.
+func RenderQR(text string, size int) (image.Image, error) {
+	code, err := qr.Encode(text, qr.L)
+	if err != nil {
+		return nil, err
+	}
+
+	modules := code.Size + 2*qrQuietZone
+	scale := size / modules
+	if scale < 1 {
+		scale = 1
+	}
+	side := modules * scale
+
+	img := image.NewPaletted(image.Rect(0, 0, side, side), color.Palette{color.White, color.Black})
+	for y := 0; y < code.Size; y++ {
+		for x := 0; x < code.Size; x++ {
+			if !code.Black(x, y) {
+				continue
+			}
+			left := (x + qrQuietZone) * scale
+			top := (y + qrQuietZone) * scale
+			for dy := 0; dy < scale; dy++ {
+				for dx := 0; dx < scale; dx++ {
+					img.SetColorIndex(left+dx, top+dy, 1)
+				}
+			}
+		}
+	}
+
+	return img, nil
+}
@@ -0,0 +1,279 @@
+package telegram
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"telegram-gateway/storage"
+	"time"
+
+	"github.com/gotd/td/tg"
+)
+
+// Скільки надісланих повідомлень і як довго пам'ятається для повторних запитів
+const (
+	sentLimit = 500
+	sentTTL   = 24 * time.Hour
+)
+
+// SentMessage - результат надсилання повідомлення
+type SentMessage struct {
+	ID     int
+	ChatID int64
+	Date   time.Time
+	// Текст, як його зберіг Telegram
+	Text string
+	// Повторний запит: повідомлення вже було надіслане раніше
+	Duplicate bool
+}
+
+// sentRecord - надіслане повідомлення в журналі повторів.
+// ChatID - позначений ID (див. MarkedPeerID), а не те, що передав клієнт.
+type sentRecord struct {
+	ChatID int64  `json:"chat_id"`
+	ID     int    `json:"id"`
+	Date   int64  `json:"date"`
+	Text   string `json:"text"`
+	SentAt int64  `json:"sent_at"`
+}
+
+// SentKey повертає ключ журналу надісланих повідомлень для сесії sessionKey
+func SentKey(sessionKey string) string {
+	return "sent:" + sessionKey
+}
+
+// IdempotencyRandomID перетворює ключ ідемпотентності клієнта на random_id
+func IdempotencyRandomID(key string) int64 {
+	sum := sha256.Sum256([]byte(key))
+	id := int64(binary.LittleEndian.Uint64(sum[:8]))
+	if id == 0 {
+		id = 1
+	}
+	return id
+}
+
+// sentLog пам'ятає random_id надісланих повідомлень акаунта, щоб повторний
+// запит (наприклад, після таймауту GPRS) повернув те саме повідомлення,
+// а не надіслав його вдруге. Зберігається в SessionStore.
+type sentLog struct {
+	store storage.SessionStore
+	key   string
+
+	mu      sync.Mutex
+	records map[int64]sentRecord
+	pending map[int64]chan struct{}
+	// ID повідомлень за random_id з UpdateMessageID (лише в пам'яті)
+	ids map[int64]int
+}
+
+func newSentLog(store storage.SessionStore, key string) *sentLog {
+	return &sentLog{
+		store:   store,
+		key:     key,
+		pending: make(map[int64]chan struct{}),
+		ids:     make(map[int64]int),
+	}
+}
+
+// observe запам'ятовує ID повідомлення, яке Telegram видав для random_id
+func (l *sentLog) observe(randomID int64, id int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.ids) >= sentLimit {
+		clear(l.ids)
+	}
+	l.ids[randomID] = id
+}
+
+// messageID повертає ID повідомлення з UpdateMessageID для random_id (0, якщо невідомий)
+func (l *sentLog) messageID(randomID int64) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.ids[randomID]
+}
+
+// load ліниво читає журнал зі сховища; викликається під l.mu
+func (l *sentLog) load(ctx context.Context) error {
+	if l.records != nil {
+		return nil
+	}
+
+	records := make(map[int64]sentRecord)
+	data, err := l.store.Load(ctx, l.key)
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+	case err != nil:
+		return fmt.Errorf("failed to load sent messages: %w", err)
+	default:
+		if err := json.Unmarshal(data, &records); err != nil {
+			return fmt.Errorf("failed to decode sent messages: %w", err)
+		}
+	}
+	l.records = records
+	return nil
+}
+
+// do виконує send для random_id лише раз. Паралельний повтор чекає на
+// перший запит; повтор після успіху отримує збережений результат.
+func (l *sentLog) do(ctx context.Context, chatID, randomID int64, send func() (*SentMessage, error)) (*SentMessage, error) {
+	for {
+		l.mu.Lock()
+		if err := l.load(ctx); err != nil {
+			l.mu.Unlock()
+			return nil, err
+		}
+		if record, ok := l.records[randomID]; ok {
+			l.mu.Unlock()
+			if record.ChatID != chatID {
+				return nil, &MessageError{
+					Code: MessageErrKeyConflict,
+					Err:  fmt.Errorf("random_id %d was already used in chat %d", randomID, record.ChatID),
+				}
+			}
+			return &SentMessage{
+				ID:        record.ID,
+				ChatID:    record.ChatID,
+				Date:      time.Unix(record.Date, 0),
+				Text:      record.Text,
+				Duplicate: true,
+			}, nil
+		}
+
+		wait, busy := l.pending[randomID]
+		if !busy {
+			l.pending[randomID] = make(chan struct{})
+			l.mu.Unlock()
+			break
+		}
+		l.mu.Unlock()
+
+		select {
+		case <-wait:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	sent, err := send()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	close(l.pending[randomID])
+	delete(l.pending, randomID)
+	// Після помилки повтор можливий: Telegram сам відхилить той самий random_id
+	if err != nil {
+		return nil, err
+	}
+
+	l.records[randomID] = sentRecord{
+		ChatID: sent.ChatID,
+		ID:     sent.ID,
+		Date:   sent.Date.Unix(),
+		Text:   sent.Text,
+		SentAt: time.Now().Unix(),
+	}
+	l.prune()
+	if err := l.save(ctx); err != nil {
+		// Повідомлення вже надіслане - помилка сховища лише послаблює захист від повторів
+		log.Printf("SentLog: Failed to save %s: %v", l.key, err)
+	}
+	return sent, nil
+}
+
+// prune забуває застарілі записи і тримає журнал у межах sentLimit
+func (l *sentLog) prune() {
+	deadline := time.Now().Add(-sentTTL).Unix()
+	for id, record := range l.records {
+		if record.SentAt < deadline {
+			delete(l.records, id)
+		}
+	}
+	for len(l.records) > sentLimit {
+		var oldestID, oldest int64
+		for id, record := range l.records {
+			if oldest == 0 || record.SentAt < oldest {
+				oldestID, oldest = id, record.SentAt
+			}
+		}
+		delete(l.records, oldestID)
+	}
+}
+
+func (l *sentLog) save(ctx context.Context) error {
+	data, err := json.Marshal(l.records)
+	if err != nil {
+		return err
+	}
+	return l.store.Save(ctx, l.key, data)
+}
+
+// updatesList повертає оновлення з відповіді на RPC
+func updatesList(updates tg.UpdatesClass) []tg.UpdateClass {
+	switch u := updates.(type) {
+	case *tg.Updates:
+		return u.Updates
+	case *tg.UpdatesCombined:
+		return u.Updates
+	case *tg.UpdateShort:
+		return []tg.UpdateClass{u.Update}
+	}
+	return nil
+}
+
+// sentFromUpdates знаходить у відповіді на sendMessage надіслане повідомлення:
+// UpdateMessageID дає його ID за random_id, а UpdateNewMessage або
+// UpdateNewChannelMessage з тим самим ID - дату і остаточний текст.
+func sentFromUpdates(updates tg.UpdatesClass, chatID, randomID int64, text string) *SentMessage {
+	sent := &SentMessage{ChatID: chatID, Date: time.Now(), Text: text}
+
+	if short, ok := updates.(*tg.UpdateShortSentMessage); ok {
+		sent.ID = short.ID
+		sent.Date = time.Unix(int64(short.Date), 0)
+		return sent
+	}
+
+	sent.ID = sentMessageIDs(updates)[randomID]
+	if sent.ID == 0 {
+		return sent
+	}
+	for _, update := range updatesList(updates) {
+		var m tg.MessageClass
+		switch u := update.(type) {
+		case *tg.UpdateNewMessage:
+			m = u.Message
+		case *tg.UpdateNewChannelMessage:
+			m = u.Message
+		}
+		if msg, ok := m.(*tg.Message); ok && msg.ID == sent.ID {
+			sent.Date = time.Unix(int64(msg.Date), 0)
+			sent.Text = msg.Message
+			break
+		}
+	}
+	return sent
+}
+
+// findSent знаходить повідомлення, надіслане з randomID, за UpdateMessageID,
+// що надійшов з оновленнями. Потрібно, коли Telegram відхилив повтор
+// (RANDOM_ID_DUPLICATE), а журнал повторів результату першої спроби не має.
+// Якщо ID невідомий (наприклад, після перезапуску) - MessageErrDuplicate.
+func (c *Client) findSent(ctx context.Context, chatID, randomID int64) (*SentMessage, error) {
+	if id := c.sent.messageID(randomID); id != 0 {
+		page, err := c.GetHistory(ctx, chatID, HistoryQuery{Limit: 1, BeforeID: id + 1})
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range page.Messages {
+			if m.ID == id && m.Out {
+				return &SentMessage{ID: m.ID, ChatID: chatID, Date: m.Timestamp, Text: m.raw, Duplicate: true}, nil
+			}
+		}
+	}
+	return nil, &MessageError{Code: MessageErrDuplicate, Err: errors.New("message with this random_id was already sent")}
+}
@@ -0,0 +1,29 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"telegram-gateway/storage"
+
+	"github.com/gotd/td/session"
+)
+
+// sessionStorage адаптує storage.SessionStore до telegram.SessionStorage
+type sessionStorage struct {
+	store storage.SessionStore
+	key   string
+}
+
+// LoadSession завантажує MTProto сесію акаунта
+func (s *sessionStorage) LoadSession(ctx context.Context) ([]byte, error) {
+	data, err := s.store.Load(ctx, s.key)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, session.ErrNotFound
+	}
+	return data, err
+}
+
+// StoreSession зберігає MTProto сесію акаунта
+func (s *sessionStorage) StoreSession(ctx context.Context, data []byte) error {
+	return s.store.Save(ctx, s.key, data)
+}
@@ -0,0 +1,296 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"telegram-gateway/storage"
+	"time"
+
+	"github.com/gotd/td/telegram/updates"
+)
+
+// Як часто щонайбільше стан оновлень записується в сховище
+const updateStateSaveInterval = time.Second
+
+// channelState - позиція в потоці оновлень каналу
+type channelState struct {
+	Pts        int   `json:"pts"`
+	AccessHash int64 `json:"access_hash"`
+}
+
+// persistedState - стан потоку оновлень акаунта у сховищі
+type persistedState struct {
+	Found    bool                   `json:"found"`
+	State    updates.State          `json:"state"`
+	Channels map[int64]channelState `json:"channels"`
+}
+
+// UpdateStateStorage зберігає pts/qts/seq акаунта та його каналів у SessionStore.
+// Завдяки цьому після перезапуску шлюзу або перепідключення менеджер оновлень
+// дозавантажує пропущене через getDifference/getChannelDifference.
+// Зміни накопичуються в пам'яті і записуються не частіше за
+// updateStateSaveInterval, а незбережене - під час Close.
+// Реалізує updates.StateStorage і updates.ChannelAccessHasher.
+type UpdateStateStorage struct {
+	store storage.SessionStore
+	key   string
+	peers *PeerStore
+
+	mu        sync.Mutex
+	state     *persistedState
+	dirty     bool
+	scheduled bool
+	closed    bool
+}
+
+// UpdateStateKey повертає ключ стану оновлень для сесії sessionKey
+func UpdateStateKey(sessionKey string) string {
+	return "updates:" + sessionKey
+}
+
+// NewUpdateStateStorage створює сховище стану оновлень під ключем key
+func NewUpdateStateStorage(store storage.SessionStore, key string, peers *PeerStore) *UpdateStateStorage {
+	return &UpdateStateStorage{store: store, key: key, peers: peers}
+}
+
+// load ліниво читає стан зі сховища; викликається під s.mu
+func (s *UpdateStateStorage) load(ctx context.Context) (*persistedState, error) {
+	if s.state != nil {
+		return s.state, nil
+	}
+
+	state := &persistedState{Channels: make(map[int64]channelState)}
+	data, err := s.store.Load(ctx, s.key)
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+	case err != nil:
+		return nil, fmt.Errorf("failed to load update state: %w", err)
+	default:
+		if err := json.Unmarshal(data, state); err != nil {
+			return nil, fmt.Errorf("failed to decode update state: %w", err)
+		}
+		if state.Channels == nil {
+			state.Channels = make(map[int64]channelState)
+		}
+	}
+
+	// Відомі access hash каналів потрібні одразу для getChannelDifference
+	for id, channel := range state.Channels {
+		if channel.AccessHash != 0 {
+			s.peers.SetChannelAccessHash(ctx, 0, id, channel.AccessHash)
+		}
+	}
+
+	s.state = state
+	return state, nil
+}
+
+// update змінює стан і, якщо fn повідомила про зміни, планує його запис
+func (s *UpdateStateStorage) update(ctx context.Context, requireState bool, fn func(state *persistedState) bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state, err := s.load(ctx)
+	if err != nil {
+		return err
+	}
+	if requireState && !state.Found {
+		return fmt.Errorf("update state not found")
+	}
+
+	if !fn(state) {
+		return nil
+	}
+
+	s.dirty = true
+	if !s.scheduled && !s.closed {
+		s.scheduled = true
+		time.AfterFunc(updateStateSaveInterval, s.scheduledFlush)
+	}
+	return nil
+}
+
+// scheduledFlush записує накопичені зміни за таймером
+func (s *UpdateStateStorage) scheduledFlush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.scheduled = false
+	if err := s.flush(context.Background()); err != nil {
+		// Стан лишається зміненим: запис повториться з наступним оновленням
+		log.Printf("Updates: Failed to save state %s: %v", s.key, err)
+	}
+}
+
+// flush записує стан, якщо він змінився; викликається під s.mu
+func (s *UpdateStateStorage) flush(ctx context.Context) error {
+	if !s.dirty || s.closed {
+		return nil
+	}
+
+	data, err := json.Marshal(s.state)
+	if err != nil {
+		return err
+	}
+	if err := s.store.Save(ctx, s.key, data); err != nil {
+		return err
+	}
+	s.dirty = false
+	return nil
+}
+
+// Close записує незбережені зміни і зупиняє подальші записи
+// (з'єднання акаунта закрите)
+func (s *UpdateStateStorage) Close(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.flush(ctx)
+	s.closed = true
+	return err
+}
+
+func (s *UpdateStateStorage) GetState(ctx context.Context, _ int64) (updates.State, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state, err := s.load(ctx)
+	if err != nil {
+		return updates.State{}, false, err
+	}
+	return state.State, state.Found, nil
+}
+
+func (s *UpdateStateStorage) SetState(ctx context.Context, _ int64, value updates.State) error {
+	return s.update(ctx, false, func(state *persistedState) bool {
+		state.Found = true
+		state.State = value
+		// Новий стан акаунта скидає позиції каналів, але не їхні access hash
+		for id, channel := range state.Channels {
+			if channel.AccessHash == 0 {
+				delete(state.Channels, id)
+				continue
+			}
+			channel.Pts = 0
+			state.Channels[id] = channel
+		}
+		return true
+	})
+}
+
+func (s *UpdateStateStorage) SetPts(ctx context.Context, _ int64, pts int) error {
+	return s.update(ctx, true, func(state *persistedState) bool {
+		state.State.Pts = pts
+		return true
+	})
+}
+
+func (s *UpdateStateStorage) SetQts(ctx context.Context, _ int64, qts int) error {
+	return s.update(ctx, true, func(state *persistedState) bool {
+		state.State.Qts = qts
+		return true
+	})
+}
+
+func (s *UpdateStateStorage) SetDate(ctx context.Context, _ int64, date int) error {
+	return s.update(ctx, true, func(state *persistedState) bool {
+		state.State.Date = date
+		return true
+	})
+}
+
+func (s *UpdateStateStorage) SetSeq(ctx context.Context, _ int64, seq int) error {
+	return s.update(ctx, true, func(state *persistedState) bool {
+		state.State.Seq = seq
+		return true
+	})
+}
+
+func (s *UpdateStateStorage) SetDateSeq(ctx context.Context, _ int64, date, seq int) error {
+	return s.update(ctx, true, func(state *persistedState) bool {
+		state.State.Date = date
+		state.State.Seq = seq
+		return true
+	})
+}
+
+func (s *UpdateStateStorage) GetChannelPts(ctx context.Context, _, channelID int64) (int, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state, err := s.load(ctx)
+	if err != nil {
+		return 0, false, err
+	}
+	channel, ok := state.Channels[channelID]
+	if !ok || channel.Pts == 0 {
+		return 0, false, nil
+	}
+	return channel.Pts, true, nil
+}
+
+func (s *UpdateStateStorage) SetChannelPts(ctx context.Context, _, channelID int64, pts int) error {
+	return s.update(ctx, false, func(state *persistedState) bool {
+		channel := state.Channels[channelID]
+		channel.Pts = pts
+		state.Channels[channelID] = channel
+		return true
+	})
+}
+
+func (s *UpdateStateStorage) ForEachChannels(ctx context.Context, _ int64, f func(ctx context.Context, channelID int64, pts int) error) error {
+	s.mu.Lock()
+	state, err := s.load(ctx)
+	if err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	channels := make(map[int64]int, len(state.Channels))
+	for id, channel := range state.Channels {
+		if channel.Pts != 0 {
+			channels[id] = channel.Pts
+		}
+	}
+	s.mu.Unlock()
+
+	for id, pts := range channels {
+		if err := f(ctx, id, pts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *UpdateStateStorage) SetChannelAccessHash(ctx context.Context, userID, channelID, accessHash int64) error {
+	s.peers.SetChannelAccessHash(ctx, userID, channelID, accessHash)
+
+	return s.update(ctx, false, func(state *persistedState) bool {
+		channel := state.Channels[channelID]
+		if channel.AccessHash == accessHash {
+			return false
+		}
+		channel.AccessHash = accessHash
+		state.Channels[channelID] = channel
+		return true
+	})
+}
+
+func (s *UpdateStateStorage) GetChannelAccessHash(ctx context.Context, userID, channelID int64) (int64, bool, error) {
+	s.mu.Lock()
+	state, err := s.load(ctx)
+	if err != nil {
+		s.mu.Unlock()
+		return 0, false, err
+	}
+	channel, ok := state.Channels[channelID]
+	s.mu.Unlock()
+
+	if ok && channel.AccessHash != 0 {
+		return channel.AccessHash, true, nil
+	}
+	return s.peers.GetChannelAccessHash(ctx, userID, channelID)
+}
@@ -2,18 +2,26 @@ package telegram
 
 import (
 	"context"
+	"fmt"
 	"log"
-	"sync"
 
 	"github.com/gotd/td/telegram/updates"
 	"github.com/gotd/td/tg"
 )
 
-var (
-	updateChannels     = make(map[string]chan *tg.UpdateNewMessage)
-	updateChannelMutex sync.RWMutex
+const (
+	// Скільки останніх нових повідомлень тримати для long polling
+	recentMessagesLimit = 100
+	// Розмір черги одного підписника
+	subscriptionBuffer = 100
 )
 
+// Subscription - черга нових повідомлень акаунта для одного підписника
+type Subscription struct {
+	C  chan Message
+	id int
+}
+
 // UpdatesHandler обробляє оновлення від Telegram
 type UpdatesHandler struct {
 	client *Client
@@ -21,84 +29,248 @@ type UpdatesHandler struct {
 
 // Handle обробляє оновлення
 func (h *UpdatesHandler) Handle(ctx context.Context, u tg.UpdatesClass) error {
+	h.client.peers.applyUpdates(u)
+
 	switch upd := u.(type) {
 	case *tg.Updates:
+		users := usersMap(upd.Users)
+		for _, update := range upd.Updates {
+			h.handleUpdate(update, users)
+		}
+	case *tg.UpdatesCombined:
+		users := usersMap(upd.Users)
 		for _, update := range upd.Updates {
-			h.handleUpdate(update)
+			h.handleUpdate(update, users)
 		}
 	case *tg.UpdateShort:
-		h.handleUpdate(upd.Update)
+		h.handleUpdate(upd.Update, nil)
 	}
 	return nil
 }
 
 // handleUpdate обробляє окреме оновлення
-func (h *UpdatesHandler) handleUpdate(u tg.UpdateClass) {
+func (h *UpdatesHandler) handleUpdate(u tg.UpdateClass, users map[int64]*tg.User) {
+	c := h.client
+
 	switch upd := u.(type) {
+	case *tg.UpdateMessageID:
+		// Для findSent: ID власного повідомлення за його random_id
+		c.sent.observe(upd.RandomID, upd.ID)
 	case *tg.UpdateNewMessage:
-		h.handleNewMessage(upd)
+		h.handleNewMessage(upd.Message, users)
 	case *tg.UpdateNewChannelMessage:
-		// Конвертуємо в UpdateNewMessage
-		h.handleNewMessage(&tg.UpdateNewMessage{
-			Message: upd.Message,
+		h.handleNewMessage(upd.Message, users)
+	case *tg.UpdateEditMessage:
+		h.handleEditMessage(upd.Message, users)
+	case *tg.UpdateEditChannelMessage:
+		h.handleEditMessage(upd.Message, users)
+	case *tg.UpdateDeleteMessages:
+		// Для особистих чатів і груп Telegram не передає чат
+		c.emit(Event{Type: EventDeleteMessages, MessageIDs: upd.Messages})
+	case *tg.UpdateDeleteChannelMessages:
+		c.emit(Event{
+			Type:       EventDeleteMessages,
+			ChatID:     formatChatID(MarkedPeerID(&tg.PeerChannel{ChannelID: upd.ChannelID})),
+			MessageIDs: upd.Messages,
+		})
+	case *tg.UpdateReadHistoryInbox:
+		unread := upd.StillUnreadCount
+		c.emit(Event{
+			Type:        EventReadInbox,
+			ChatID:      formatChatID(MarkedPeerID(upd.Peer)),
+			MaxID:       upd.MaxID,
+			UnreadCount: &unread,
+		})
+	case *tg.UpdateReadChannelInbox:
+		unread := upd.StillUnreadCount
+		c.emit(Event{
+			Type:        EventReadInbox,
+			ChatID:      formatChatID(MarkedPeerID(&tg.PeerChannel{ChannelID: upd.ChannelID})),
+			MaxID:       upd.MaxID,
+			UnreadCount: &unread,
 		})
+	case *tg.UpdateReadHistoryOutbox:
+		c.emit(Event{
+			Type:   EventReadOutbox,
+			ChatID: formatChatID(MarkedPeerID(upd.Peer)),
+			MaxID:  upd.MaxID,
+		})
+	case *tg.UpdateReadChannelOutbox:
+		c.emit(Event{
+			Type:   EventReadOutbox,
+			ChatID: formatChatID(MarkedPeerID(&tg.PeerChannel{ChannelID: upd.ChannelID})),
+			MaxID:  upd.MaxID,
+		})
+	case *tg.UpdateDialogUnreadMark:
+		if chatID, ok := dialogPeerChatID(upd.Peer); ok {
+			unread := upd.Unread
+			c.emit(Event{
+				Type:   EventUnreadMark,
+				ChatID: formatChatID(chatID),
+				Unread: &unread,
+			})
+		}
+	case *tg.UpdateLoginToken:
+		// QR-токен прийнято в іншому застосунку (див. AuthHandler.RunQR)
+		select {
+		case c.loginToken <- struct{}{}:
+		default:
+		}
 	}
 }
 
 // handleNewMessage обробляє нові повідомлення
-func (h *UpdatesHandler) handleNewMessage(upd *tg.UpdateNewMessage) {
-	msg, ok := upd.Message.(*tg.Message)
+func (h *UpdatesHandler) handleNewMessage(m tg.MessageClass, users map[int64]*tg.User) {
+	msg, ok := m.(*tg.Message)
+	if !ok {
+		return
+	}
+
+	message, ok := h.client.convertMessage(msg, users)
 	if !ok {
 		return
 	}
 
-	log.Printf("New message: %s", msg.Message)
+	// Відправляємо оновлення всім підписникам акаунта
+	h.client.publish(message)
+	h.client.emit(Event{
+		Type:    EventNewMessage,
+		ChatID:  message.ChatID,
+		Message: &message,
+		Date:    message.Timestamp,
+	})
+}
 
-	// Відправляємо оновлення всім підписаним клієнтам
-	updateChannelMutex.RLock()
-	defer updateChannelMutex.RUnlock()
+// handleEditMessage обробляє редагування повідомлень
+func (h *UpdatesHandler) handleEditMessage(m tg.MessageClass, users map[int64]*tg.User) {
+	msg, ok := m.(*tg.Message)
+	if !ok {
+		return
+	}
 
-	for _, ch := range updateChannels {
-		select {
-		case ch <- upd:
-		default:
-			// Канал переповнений, пропускаємо
+	message, ok := h.client.convertMessage(msg, users)
+	if !ok {
+		return
+	}
+
+	h.client.emit(Event{
+		Type:    EventEditMessage,
+		ChatID:  message.ChatID,
+		Message: &message,
+	})
+}
+
+func usersMap(list []tg.UserClass) map[int64]*tg.User {
+	users := make(map[int64]*tg.User, len(list))
+	for _, u := range list {
+		if user, ok := u.(*tg.User); ok {
+			users[user.ID] = user
 		}
 	}
+	return users
 }
 
-// StartUpdatesListener запускає слухач оновлень
-func (c *Client) StartUpdatesListener(ctx context.Context) error {
-	return c.Client.Run(ctx, func(ctx context.Context) error {
-		// Отримуємо API клієнт всередині Run callback
-		api := c.Client.API()
+// StartUpdatesListener запускає менеджер оновлень всередині постійного з'єднання.
+// Менеджер сам закриває прогалини (getDifference) і працює, поки живе з'єднання.
+func (c *Client) StartUpdatesListener() error {
+	self := c.Self()
+
+	c.mu.Lock()
+	ctx := c.connCtx
+	c.mu.Unlock()
 
-		gaps := updates.New(updates.Config{
-			Handler: &UpdatesHandler{client: c},
+	if ctx == nil {
+		return fmt.Errorf("client is not started")
+	}
+	if self == nil {
+		return ErrNotAuthorized
+	}
+
+	go func() {
+		err := c.gaps.Run(ctx, c.Client.API(), self.ID, updates.AuthOptions{
+			OnStart: func(ctx context.Context) {
+				log.Printf("Updates: Listening for account %d", self.ID)
+			},
 		})
+		if err != nil && ctx.Err() == nil {
+			// Без оновлень з'єднання марне - закриваємо, щоб пул створив нове
+			log.Printf("Updates: Listener for account %d stopped: %v", self.ID, err)
+			c.mu.Lock()
+			cancel := c.cancel
+			c.mu.Unlock()
+			cancel()
+		}
+	}()
 
-		// Реєструємо обробник оновлень
-		return gaps.Run(ctx, api, 0, updates.AuthOptions{})
-	})
+	return nil
+}
+
+// Subscribe підписується на нові повідомлення акаунта
+func (c *Client) Subscribe() *Subscription {
+	c.subMu.Lock()
+	defer c.subMu.Unlock()
+
+	c.nextSubID++
+	sub := &Subscription{
+		C:  make(chan Message, subscriptionBuffer),
+		id: c.nextSubID,
+	}
+	c.subs[sub.id] = sub
+	return sub
+}
+
+// Unsubscribe відписується від оновлень
+func (c *Client) Unsubscribe(sub *Subscription) {
+	c.subMu.Lock()
+	defer c.subMu.Unlock()
+
+	if _, exists := c.subs[sub.id]; exists {
+		close(sub.C)
+		delete(c.subs, sub.id)
+	}
+}
+
+// RecentMessages повертає нові повідомлення чату з ID > afterID,
+// що надійшли через оновлення
+func (c *Client) RecentMessages(chatID int64, afterID int) []Message {
+	c.subMu.Lock()
+	defer c.subMu.Unlock()
+
+	var result []Message
+	for _, msg := range c.recent {
+		if msg.ID > afterID && msg.IsInChat(chatID) {
+			result = append(result, msg)
+		}
+	}
+	return result
 }
 
-// SubscribeToUpdates підписується на оновлення
-func SubscribeToUpdates(sessionToken string) chan *tg.UpdateNewMessage {
-	updateChannelMutex.Lock()
-	defer updateChannelMutex.Unlock()
+// publish розсилає нове повідомлення підписникам
+func (c *Client) publish(message Message) {
+	c.subMu.Lock()
+	defer c.subMu.Unlock()
 
-	ch := make(chan *tg.UpdateNewMessage, 100)
-	updateChannels[sessionToken] = ch
-	return ch
+	c.recent = append(c.recent, message)
+	if len(c.recent) > recentMessagesLimit {
+		c.recent = c.recent[len(c.recent)-recentMessagesLimit:]
+	}
+
+	for _, sub := range c.subs {
+		select {
+		case sub.C <- message:
+		default:
+			// Канал переповнений, пропускаємо
+		}
+	}
 }
 
-// UnsubscribeFromUpdates відписується від оновлень
-func UnsubscribeFromUpdates(sessionToken string) {
-	updateChannelMutex.Lock()
-	defer updateChannelMutex.Unlock()
+// closeSubscribers закриває черги всіх підписників (з'єднання завершене)
+func (c *Client) closeSubscribers() {
+	c.subMu.Lock()
+	defer c.subMu.Unlock()
 
-	if ch, exists := updateChannels[sessionToken]; exists {
-		close(ch)
-		delete(updateChannels, sessionToken)
+	for id, sub := range c.subs {
+		close(sub.C)
+		delete(c.subs, id)
 	}
 }